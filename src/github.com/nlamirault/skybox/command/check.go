@@ -83,7 +83,7 @@ func (c *CheckCommand) Run(args []string) int {
 		c.UI.Error(err.Error())
 		return 1
 	}
-	log.Printf("[DEBUG] Skybox Client: %s", agent)
+	log.Printf("[DEBUG] Skybox Client: %v", agent)
 
 	action := args[0]
 	switch action {
@@ -0,0 +1,189 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	// defaultCollector is the collector always enabled
+	defaultCollector = "connection"
+	// internalCollector writes the internal metrics of skybox
+	internalCollector = "internal"
+)
+
+// collector creates the points of a group of box statistics
+type collector func(box string, resp *providers.ProviderConnectionStatistics, now time.Time) ([]*client.Point, error)
+
+// collectors are the available collectors. The collectors other than the
+// default one are enabled by their interval, if the provider supports them.
+var collectors = map[string]collector{
+	"connection": connectionPoints,
+	"system":     systemPoints,
+	"lan":        lanPoints,
+	"wifi":       wifiPoints,
+}
+
+// collectorIntervals returns the interval of each enabled collector. The
+// default collector uses the global interval, unless it is configured.
+func collectorIntervals(conf *config.Configuration, provider providers.Provider) (map[string]time.Duration, error) {
+	intervals := map[string]time.Duration{
+		defaultCollector: time.Duration(conf.Interval) * time.Second,
+	}
+	names := make([]string, 0, len(conf.Intervals))
+	for name := range conf.Intervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := collectors[name]; !ok && name != internalCollector {
+			return nil, fmt.Errorf("Unknown collector: %s", name)
+		}
+		if !supportedCollector(provider, name) {
+			return nil, fmt.Errorf("Collector %s not supported by the box provider %s",
+				name, provider.Description())
+		}
+		intervals[name] = time.Duration(conf.Intervals[name]) * time.Second
+	}
+	for name, interval := range intervals {
+		if interval <= 0 {
+			return nil, fmt.Errorf("Invalid interval for collector %s: %s", name, interval)
+		}
+	}
+	return intervals, nil
+}
+
+// supportedCollector reports whether the provider retrieves the statistics
+// of a collector
+func supportedCollector(provider providers.Provider, name string) bool {
+	if name == defaultCollector || name == internalCollector {
+		return true
+	}
+	p, ok := provider.(providers.CollectorProvider)
+	if !ok {
+		return false
+	}
+	for _, collector := range p.Collectors() {
+		if collector == name {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionPoints returns the rate, bytes, bandwidth and connection state
+func connectionPoints(box string, resp *providers.ProviderConnectionStatistics, now time.Time) ([]*client.Point, error) {
+	var points []*client.Point
+
+	rateTags := map[string]string{"rate": "rate-up-down", "box": box}
+	rateFields := map[string]interface{}{
+		"up":   resp.RateUp,
+		"down": resp.RateDown,
+	}
+	ratePt, err := client.NewPoint("rate", rateTags, rateFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating rate statistics for output: %s", err.Error())
+	}
+	points = append(points, ratePt)
+
+	bytesTags := map[string]string{"bytes": "bytes-up-down", "box": box}
+	bytesFields := map[string]interface{}{
+		"up":   resp.BytesUp,
+		"down": resp.BytesDown,
+	}
+	bytesPt, err := client.NewPoint("bytes", bytesTags, bytesFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating bytes statistics for output: %s", err.Error())
+	}
+	points = append(points, bytesPt)
+
+	bandwidthTags := map[string]string{"bandwidth": "bandwidth-up-down", "box": box}
+	bandwidthFields := map[string]interface{}{
+		"up":   resp.BandwidthUp,
+		"down": resp.BandwidthDown,
+	}
+	bandwidthPt, err := client.NewPoint("bandwidth", bandwidthTags, bandwidthFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating bandwidth statistics for output: %s", err.Error())
+	}
+	points = append(points, bandwidthPt)
+
+	if resp.State != "" {
+		connectionTags := map[string]string{"box": box}
+		connectionFields := map[string]interface{}{
+			"state":  resp.State,
+			"uptime": resp.Uptime,
+		}
+		if resp.IPv4 != "" {
+			connectionFields["ipv4"] = resp.IPv4
+		}
+		connectionPt, err := client.NewPoint("connection", connectionTags, connectionFields, now)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating connection statistics for output: %s", err.Error())
+		}
+		points = append(points, connectionPt)
+	}
+	return points, nil
+}
+
+// systemPoints returns the uptime, load and memory of the box
+func systemPoints(box string, resp *providers.ProviderConnectionStatistics, now time.Time) ([]*client.Point, error) {
+	systemTags := map[string]string{"box": box}
+	systemFields := map[string]interface{}{
+		"uptime":       resp.SystemUptime,
+		"load":         resp.Load,
+		"memory_total": resp.MemoryTotal,
+		"memory_free":  resp.MemoryFree,
+	}
+	systemPt, err := client.NewPoint("system", systemTags, systemFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating system statistics for output: %s", err.Error())
+	}
+	return []*client.Point{systemPt}, nil
+}
+
+// lanPoints returns the number of hosts of the local network
+func lanPoints(box string, resp *providers.ProviderConnectionStatistics, now time.Time) ([]*client.Point, error) {
+	lanTags := map[string]string{"box": box}
+	lanFields := map[string]interface{}{
+		"hosts": resp.LANHosts,
+	}
+	lanPt, err := client.NewPoint("lan", lanTags, lanFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating lan statistics for output: %s", err.Error())
+	}
+	return []*client.Point{lanPt}, nil
+}
+
+// wifiPoints returns the number of wireless clients
+func wifiPoints(box string, resp *providers.ProviderConnectionStatistics, now time.Time) ([]*client.Point, error) {
+	wifiTags := map[string]string{"box": box}
+	wifiFields := map[string]interface{}{
+		"clients": resp.WirelessClients,
+	}
+	wifiPt, err := client.NewPoint("wifi", wifiTags, wifiFields, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating wifi statistics for output: %s", err.Error())
+	}
+	return []*client.Point{wifiPt}, nil
+}
@@ -19,6 +19,7 @@ import (
 	"log"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/mitchellh/go-homedir"
 
@@ -70,6 +71,16 @@ func getConfigurationFile() (string, error) {
 type Agent struct {
 	Provider providers.Provider
 	Output   outputs.Output
+
+	// mutex serializes the collections, as the provider isn't safe for
+	// concurrent use. writeMutex serializes the writes to the output, so
+	// their retries don't block the collections.
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+	// outputName is the output plugin name, used in the internal metrics
+	outputName string
+	// recorder records the exchanges with the box, if enabled
+	recorder *providers.Recorder
 }
 
 func getConfiguration(filename string) (*config.Configuration, error) {
@@ -91,13 +102,59 @@ func NewAgent(conf *config.Configuration) (*Agent, error) {
 	log.Printf("[DEBUG] Box Provider: %s\n", provider)
 	log.Printf("[DEBUG] Output Plugins: %v\n", outputs.Outputs)
 	outputCreator := outputs.Outputs[conf.OutputPlugin]
-	if providerCreator == nil {
+	if outputCreator == nil {
 		return nil, fmt.Errorf("No output plugin found for %s", conf.OutputPlugin)
 	}
 	output := outputCreator()
 	log.Printf("[DEBUG] Output plugin: %v\n", output)
+	output = outputs.NewMeasured(output, conf.OutputPlugin)
+	if conf.FlushInterval > 0 {
+		log.Printf("[DEBUG] Output flush interval: %ds", conf.FlushInterval)
+		output = outputs.NewBatcher(output, conf)
+	}
 	return &Agent{
-		Provider: provider,
-		Output:   output,
+		Provider:   provider,
+		Output:     output,
+		outputName: conf.OutputPlugin,
 	}, nil
 }
+
+// Setup setups the box provider and the output, and connects to them
+func (a *Agent) Setup(conf *config.Configuration) error {
+	if err := a.Provider.Setup(conf); err != nil {
+		return err
+	}
+	if a.recorder != nil {
+		if err := a.recorder.Record(a.Provider); err != nil {
+			return err
+		}
+	}
+	if err := a.Provider.Authenticate(); err != nil {
+		return err
+	}
+	if err := a.Output.Setup(conf); err != nil {
+		return err
+	}
+	return a.Output.Connect()
+}
+
+// Close closes the output, which writes the buffered points, the box
+// provider session and the recording of the exchanges
+func (a *Agent) Close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.writeMutex.Lock()
+	defer a.writeMutex.Unlock()
+	if err := a.Output.Close(); err != nil {
+		log.Printf("[WARN] Error closing output: %s", err.Error())
+	}
+	if err := a.Provider.Close(); err != nil {
+		log.Printf("[WARN] Error closing box provider session: %s", err.Error())
+	}
+	if a.recorder != nil {
+		if err := a.recorder.Close(); err != nil {
+			log.Printf("[WARN] Error closing record file: %s", err.Error())
+		}
+		a.recorder = nil
+	}
+}
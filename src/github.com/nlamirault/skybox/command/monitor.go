@@ -18,13 +18,20 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/mitchellh/cli"
 
 	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/providers"
+	"github.com/nlamirault/skybox/scheduler"
+	"github.com/nlamirault/skybox/selfstat"
 )
 
 // MonitorCommand defines the CLI command to manage buckets
@@ -85,14 +92,14 @@ func (c *MonitorCommand) Run(args []string) int {
 		c.UI.Error(err.Error())
 		return 1
 	}
-	log.Printf("[DEBUG] Skybox agent: %s", agent)
+	log.Printf("[DEBUG] Skybox agent: %v", agent)
 
 	action := args[0]
 	switch action {
 	case "display":
 		c.doDisplayBoxMonitoring(agent, conf)
 	case "box":
-		c.doBoxMonitoring(agent, conf)
+		c.doBoxMonitoring(agent, conf, configFile)
 	default:
 		f.Usage()
 	}
@@ -115,85 +122,202 @@ func (c *MonitorCommand) doDisplayBoxMonitoring(agent *Agent, conf *config.Confi
 		resp.BytesUp, resp.BytesDown))
 	c.UI.Output(fmt.Sprintf("Bandwidth: [Up/Down]: %d / %d",
 		resp.BandwidthUp, resp.BandwidthDown))
+	c.UI.Output(fmt.Sprintf("State: %s / %s / %ds",
+		resp.State, resp.IPv4, resp.Uptime))
 	c.UI.Output(fmt.Sprintf("Box provider statistics successfully retrieve"))
 }
 
-func (c *MonitorCommand) doBoxMonitoring(agent *Agent, conf *config.Configuration) {
+// doBoxMonitoring runs the collectors until SIGINT or SIGTERM. The
+// buffered points are then written, and the box session and the output
+// are closed. SIGHUP reloads the configuration: a new agent replaces the
+// running one once it is set up, otherwise the running one goes on.
+func (c *MonitorCommand) doBoxMonitoring(agent *Agent, conf *config.Configuration, configFile string) {
 	c.UI.Info(fmt.Sprintf("Display box provider statistics: %s", agent.Provider.Description()))
 	log.Printf("[DEBUG] Skybox box provider: %v", agent.Provider)
 
-	if err := agent.Provider.Setup(conf); err != nil {
-		c.UI.Error(err.Error())
-		return
+	if conf.Record != "" {
+		recorder, err := providers.NewRecorder(conf.Record)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return
+		}
+		agent.recorder = recorder
 	}
-	if err := agent.Provider.Authenticate(); err != nil {
+	intervals, err := setupAgent(agent, conf)
+	if err != nil {
 		c.UI.Error(err.Error())
+		agent.Close()
 		return
 	}
-	if err := agent.Output.Setup(conf); err != nil {
-		c.UI.Error(err.Error())
-		return
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func(agent *Agent, intervals map[string]time.Duration) {
+			runCollectors(agent, intervals, stop)
+			close(done)
+		}(agent, intervals)
+
+		var next *Agent
+		for next == nil {
+			sig := <-signals
+			if sig != syscall.SIGHUP {
+				log.Printf("[INFO] Skybox stopping: %s", sig)
+				close(stop)
+				// A write waiting to be retried would delay the stop
+				outputs.Interrupt(agent.Output)
+				<-done
+				agent.Close()
+				c.UI.Info("Skybox stopped")
+				return
+			}
+			log.Printf("[INFO] Skybox reloading configuration: %s", configFile)
+			// The agent runs until the new one is ready. The recording
+			// goes on with the new agent.
+			reloaded, err := getConfiguration(configFile)
+			if err == nil {
+				next, intervals, err = startAgent(reloaded, agent.recorder)
+			}
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Configuration not reloaded: %s", err.Error()))
+				next = nil
+			}
+		}
+		close(stop)
+		<-done
+		agent.recorder = nil
+		agent.Close()
+		agent = next
+		c.UI.Info("Configuration reloaded")
 	}
-	if err := agent.Output.Connect(); err != nil {
-		c.UI.Error(err.Error())
-		return
+}
+
+// setupAgent setups the provider and the output of the agent, and returns
+// the intervals of the collectors, which must be supported by the provider
+func setupAgent(agent *Agent, conf *config.Configuration) (map[string]time.Duration, error) {
+	if err := agent.Setup(conf); err != nil {
+		return nil, err
 	}
-	tick := time.Tick(time.Second * time.Duration(conf.Interval))
-	for _ = range tick {
-		resp, err := agent.Provider.Statistics()
-		if err != nil {
-			fmt.Printf("Error with box statistics: %s\n", err.Error())
+	return collectorIntervals(conf, agent.Provider)
+}
+
+// startAgent creates an agent, and setups its provider and output. The
+// recorder, if any, isn't closed if the setup fails.
+func startAgent(conf *config.Configuration, recorder *providers.Recorder) (*Agent, map[string]time.Duration, error) {
+	agent, err := NewAgent(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	agent.recorder = recorder
+	intervals, err := setupAgent(agent, conf)
+	if err != nil {
+		agent.recorder = nil
+		agent.Close()
+		return nil, nil, err
+	}
+	return agent, intervals, nil
+}
+
+// runCollectors runs each collector at its interval until stop is closed
+func runCollectors(agent *Agent, intervals map[string]time.Duration, stop <-chan struct{}) {
+	box := agent.Provider.Description()
+	sched := scheduler.New()
+	for name, interval := range intervals {
+		name := name
+		if name == internalCollector {
+			sched.Add(name, interval, func(tick time.Time) {
+				collectInternal(agent, tick)
+			})
 			continue
 		}
+		sched.Add(name, interval, func(tick time.Time) {
+			collect(agent, name, box, tick)
+		})
+	}
+	sched.Run(stop)
+}
+
+// collect retrieves the box statistics, and writes the points of a
+// collector. The collectors run one at a time, the writes too: a write
+// retried by the output doesn't block the other collectors.
+func collect(agent *Agent, name string, box string, tick time.Time) {
+	agent.mutex.Lock()
+	points, err := collectPoints(agent, name, box, tick)
+	agent.mutex.Unlock()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+	agent.write(points, "statistics")
+}
+
+// write writes the points to the output, one write at a time
+func (a *Agent) write(points []*client.Point, what string) {
+	a.writeMutex.Lock()
+	defer a.writeMutex.Unlock()
+	if err := a.Output.Write(points); err != nil {
+		fmt.Printf("Error writing %s : %s\n", what, err.Error())
+	}
+}
+
+// collectPoints retrieves the box statistics of the collector, and records
+// the duration in milliseconds and the errors of the collector
+func collectPoints(agent *Agent, name string, box string, tick time.Time) ([]*client.Point, error) {
+	start := time.Now()
+	tags := map[string]string{"box": box, "collector": name}
+	defer func() {
+		selfstat.Set("skybox_collect", tags, "duration", time.Since(start).Seconds()*1000)
+	}()
+	resp, err := agent.statistics(name)
+	if err != nil {
+		selfstat.Add("skybox_collect", tags, "errors", 1)
+		return nil, fmt.Errorf("Error with box statistics: %s", err.Error())
+	}
+	if name == defaultCollector {
 		fmt.Printf("Rate: [Up/Down]: %d / %d\n",
 			resp.RateUp, resp.RateDown)
 		fmt.Printf("Bytes: [Up/Down]: %d / %d\n",
 			resp.BytesUp, resp.BytesDown)
 		fmt.Printf("Bandwidth: [Up/Down]: %d / %d\n",
 			resp.BandwidthUp, resp.BandwidthDown)
+	}
+	points, err := collectors[name](box, resp, tick)
+	if err != nil {
+		selfstat.Add("skybox_collect", tags, "errors", 1)
+		return nil, err
+	}
+	selfstat.Add("skybox_collect", tags, "errors", 0)
+	return points, nil
+}
 
-		var points []*client.Point
-
-		rateTags := map[string]string{"rate": "rate-up-down"}
-		rateFields := map[string]interface{}{
-			"up":   resp.RateUp,
-			"down": resp.RateDown,
-		}
-		ratePt, err := client.NewPoint("rate", rateTags, rateFields, time.Now())
-		if err != nil {
-			fmt.Printf("Error creating rate statistics for output: %s\n", err.Error())
-			continue
-		}
-		points = append(points, ratePt)
-
-		bytesTags := map[string]string{"bytes": "bytes-up-down"}
-		bytesFields := map[string]interface{}{
-			"up":   resp.BytesUp,
-			"down": resp.BytesDown,
-		}
-		bytesPt, err := client.NewPoint("bytes", bytesTags, bytesFields, time.Now())
-		if err != nil {
-			fmt.Printf("Error creating bytes statistics for output: %s\n", err.Error())
-			continue
-		}
-		points = append(points, bytesPt)
-
-		bandwidthTags := map[string]string{"bandwidth": "bandwidth-up-down"}
-		bandwidthFields := map[string]interface{}{
-			"up":   resp.BandwidthUp,
-			"down": resp.BandwidthDown,
-		}
-		bandwidthPt, err := client.NewPoint("bandwidth", bandwidthTags, bandwidthFields, time.Now())
-		if err != nil {
-			fmt.Printf("Error creating bandwidth statistics for output: %s\n", err.Error())
-			continue
-		}
-		points = append(points, bandwidthPt)
+// statistics retrieves the box statistics of a collector. Only the
+// connection collector polls the connection statistics, so the rates
+// computed from the counters cover its interval. The other collectors
+// retrieve their own statistics only.
+func (a *Agent) statistics(name string) (*providers.ProviderConnectionStatistics, error) {
+	if name == defaultCollector {
+		return a.Provider.Statistics()
+	}
+	p, ok := a.Provider.(providers.CollectorProvider)
+	if !ok {
+		return nil, fmt.Errorf("Collector %s not supported by the box provider %s",
+			name, a.Provider.Description())
+	}
+	return p.Collect(name)
+}
 
-		err = agent.Output.Write(points)
-		if err != nil {
-			fmt.Printf("Error writing statistics : %s\n", err.Error())
-			continue
-		}
+// collectInternal writes the internal metrics of skybox
+func collectInternal(agent *Agent, tick time.Time) {
+	if batcher, ok := agent.Output.(*outputs.Batcher); ok {
+		selfstat.Set("skybox_buffer", map[string]string{"output": agent.outputName},
+			"points", int64(batcher.Buffered()))
+	}
+	points, err := selfstat.Points(tick)
+	if err != nil {
+		fmt.Printf("Error creating internal metrics: %s\n", err.Error())
+		return
 	}
+	agent.write(points, "internal metrics")
 }
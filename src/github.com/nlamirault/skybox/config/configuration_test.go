@@ -76,3 +76,31 @@ database = "skybox"
 	}
 
 }
+
+func TestIntervalConfiguration(t *testing.T) {
+	templateFile, err := ioutil.TempFile("", "configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(templateFile.Name())
+	data := []byte(`interval = 1
+debug = true
+
+[intervals]
+wifi = 60
+`)
+	err = ioutil.WriteFile(templateFile.Name(), data, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration, err := LoadFileConfig(templateFile.Name())
+	if err != nil {
+		t.Fatalf("Error with configuration: %v", err)
+	}
+	if configuration.Interval != 1 || !configuration.Debug {
+		t.Fatalf("Configuration interval failed: %d", configuration.Interval)
+	}
+	if configuration.Intervals["wifi"] != 60 {
+		t.Fatalf("Configuration intervals failed: %v", configuration.Intervals)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+)
+
+// Batcher is an Output which accumulates the points, and writes them to
+// the output when the batch is full or at each flush interval. With a flush
+// interval, the writes and their retries run in the flush goroutine, not in
+// the collections. The points of a transient write failure are kept for the
+// next flush, up to the buffer limit.
+type Batcher struct {
+	Output        Output
+	BatchSize     int
+	FlushInterval time.Duration
+	// FlushJitter is the maximum random delay added to the flush interval,
+	// so several agents don't write at the same time
+	FlushJitter time.Duration
+	BufferLimit int
+
+	// mutex protects the buffer, writeMutex serializes the writes
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+	points     []*client.Point
+	done       chan struct{}
+	stopped    chan struct{}
+	// flush wakes the flush goroutine up when a batch is full
+	flush chan struct{}
+}
+
+// NewBatcher returns a Batcher of the output
+func NewBatcher(output Output, conf *config.Configuration) *Batcher {
+	return &Batcher{
+		Output:        output,
+		BatchSize:     conf.BatchSize,
+		FlushInterval: time.Duration(conf.FlushInterval) * time.Second,
+		FlushJitter:   time.Duration(conf.FlushJitter) * time.Second,
+		BufferLimit:   conf.BufferLimit,
+	}
+}
+
+func (b *Batcher) Setup(config *config.Configuration) error {
+	return b.Output.Setup(config)
+}
+
+// Connect connects the output, and starts the periodic flushes
+func (b *Batcher) Connect() error {
+	if err := b.Output.Connect(); err != nil {
+		return err
+	}
+	if b.FlushInterval > 0 && b.done == nil {
+		b.done = make(chan struct{})
+		b.stopped = make(chan struct{})
+		b.flush = make(chan struct{}, 1)
+		go b.run()
+	}
+	return nil
+}
+
+// Close stops the periodic flushes, writes the buffered points, and
+// closes the output
+func (b *Batcher) Close() error {
+	if b.done != nil {
+		close(b.done)
+		<-b.stopped
+		b.done = nil
+	}
+	if err := b.Flush(); err != nil {
+		log.Printf("[WARN] Batcher flush failed on close: %s", err.Error())
+	}
+	return b.Output.Close()
+}
+
+func (b *Batcher) Ping() error {
+	return b.Output.Ping()
+}
+
+// Interrupt interrupts the retries of the output: the next flushes write
+// the points once
+func (b *Batcher) Interrupt() {
+	Interrupt(b.Output)
+}
+
+func (b *Batcher) Description() string {
+	return b.Output.Description()
+}
+
+// Write adds the points to the buffer, and flushes it if the batch is full:
+// in the flush goroutine if any, so the caller isn't blocked by the write
+func (b *Batcher) Write(points []*client.Point) error {
+	b.mutex.Lock()
+	b.points = append(b.points, points...)
+	full := b.BatchSize > 0 && len(b.points) >= b.BatchSize
+	b.mutex.Unlock()
+	if !full {
+		return nil
+	}
+	if b.flush == nil {
+		return b.Flush()
+	}
+	select {
+	case b.flush <- struct{}{}:
+	default:
+		// A flush is already pending
+	}
+	return nil
+}
+
+// Buffered returns the number of points waiting for a flush
+func (b *Batcher) Buffered() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.points)
+}
+
+// Flush writes the buffered points, by batches of the batch size. On a
+// transient error, the points not written are kept for the next flush:
+// only the failed ones of a partial write. The
+// batches rejected by the output are dropped, and the last error returned.
+func (b *Batcher) Flush() error {
+	b.writeMutex.Lock()
+	defer b.writeMutex.Unlock()
+	b.mutex.Lock()
+	points := b.points
+	b.points = nil
+	b.mutex.Unlock()
+
+	var rejected error
+	for len(points) > 0 {
+		n := len(points)
+		if b.BatchSize > 0 && n > b.BatchSize {
+			n = b.BatchSize
+		}
+		if err := b.Output.Write(points[:n]); err != nil {
+			if Transient(err) {
+				// Only the failed points of a partial write are kept
+				var partial *PartialWriteError
+				if errors.As(err, &partial) {
+					failed := append([]*client.Point{}, partial.Failed...)
+					points = append(failed, points[n:]...)
+				}
+				b.requeue(points)
+				return err
+			}
+			// The output rejects these points: they would fail again, and
+			// block the next ones
+			log.Printf("[WARN] Batcher %d points rejected: %s", n, err.Error())
+			rejected = err
+		} else {
+			log.Printf("[DEBUG] Batcher flush %d points", n)
+		}
+		points = points[n:]
+	}
+	return rejected
+}
+
+// requeue puts back the points of a failed flush before the points
+// written since. The oldest points are dropped over the buffer limit.
+func (b *Batcher) requeue(points []*client.Point) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.points = append(points, b.points...)
+	if b.BufferLimit > 0 && len(b.points) > b.BufferLimit {
+		dropped := len(b.points) - b.BufferLimit
+		log.Printf("[WARN] Batcher buffer full, %d points dropped", dropped)
+		b.points = b.points[dropped:]
+	}
+}
+
+func (b *Batcher) run() {
+	defer close(b.stopped)
+	for {
+		delay := b.FlushInterval
+		if b.FlushJitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(b.FlushJitter)))
+		}
+		select {
+		case <-b.done:
+			return
+		case <-b.flush:
+		case <-time.After(delay):
+		}
+		if err := b.Flush(); err != nil {
+			log.Printf("[WARN] Batcher flush failed, %d points buffered: %s",
+				b.Buffered(), err.Error())
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+// fakeOutput records the sizes of the writes. The writes fail with a
+// transient error if down, and the batches of Reject points are rejected.
+type fakeOutput struct {
+	sync.Mutex
+	Writes []int
+	Down   bool
+	Reject int
+	// Partial are the failed points of a partial write, if down
+	Partial []*client.Point
+	Closed  bool
+}
+
+func (f *fakeOutput) Setup(config *config.Configuration) error { return nil }
+func (f *fakeOutput) Connect() error                           { return nil }
+func (f *fakeOutput) Ping() error                              { return nil }
+func (f *fakeOutput) Description() string                      { return "fake" }
+
+func (f *fakeOutput) Close() error {
+	f.Closed = true
+	return nil
+}
+
+func (f *fakeOutput) Write(points []*client.Point) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.Down && f.Partial != nil {
+		return &PartialWriteError{
+			Err:    &StatusError{Output: "Fake", StatusCode: http.StatusTooManyRequests, Message: "throttled"},
+			Failed: f.Partial,
+		}
+	}
+	if f.Down {
+		return &StatusError{Output: "Fake", StatusCode: http.StatusServiceUnavailable, Message: "down"}
+	}
+	if f.Reject > 0 && len(points) == f.Reject {
+		return &StatusError{Output: "Fake", StatusCode: http.StatusBadRequest, Message: "invalid points"}
+	}
+	f.Writes = append(f.Writes, len(points))
+	return nil
+}
+
+func (f *fakeOutput) writes() []int {
+	f.Lock()
+	defer f.Unlock()
+	return append([]int{}, f.Writes...)
+}
+
+func newBatchPoints(t *testing.T, n int) []*client.Point {
+	var points []*client.Point
+	for i := 0; i < n; i++ {
+		points = append(points, outputstest.Point(t, "rate", map[string]string{"box": "freebox"},
+			map[string]interface{}{"down": i}, time.Now()))
+	}
+	return points
+}
+
+func TestBatcherBatchSize(t *testing.T) {
+	output := &fakeOutput{}
+	batcher := &Batcher{Output: output, BatchSize: 4}
+	for i := 0; i < 3; i++ {
+		if err := batcher.Write(newBatchPoints(t, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writes := output.writes(); len(writes) != 1 || writes[0] != 4 || batcher.Buffered() != 2 {
+		t.Fatalf("Invalid writes: %v %d", writes, batcher.Buffered())
+	}
+	if err := batcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := output.writes(); len(writes) != 2 || writes[1] != 2 || !output.Closed {
+		t.Fatalf("Buffer not flushed on close: %v", writes)
+	}
+}
+
+func TestBatcherFlushInterval(t *testing.T) {
+	output := &fakeOutput{}
+	batcher := &Batcher{
+		Output:        output,
+		BatchSize:     1000,
+		FlushInterval: 20 * time.Millisecond,
+		FlushJitter:   10 * time.Millisecond,
+	}
+	if err := batcher.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer batcher.Close()
+	batcher.Write(newBatchPoints(t, 3))
+	batcher.Write(newBatchPoints(t, 3))
+	if writes := output.writes(); len(writes) != 0 {
+		t.Fatalf("Points written before the flush interval: %v", writes)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(output.writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if writes := output.writes(); len(writes) != 1 || writes[0] != 6 {
+		t.Fatalf("Invalid writes: %v", writes)
+	}
+}
+
+func TestBatcherFullBatchFlushInterval(t *testing.T) {
+	output := &fakeOutput{}
+	batcher := &Batcher{Output: output, BatchSize: 2, FlushInterval: time.Hour}
+	if err := batcher.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	// The write of the full batch is blocked until the output is unlocked
+	output.Lock()
+	written := make(chan error, 1)
+	go func() {
+		written <- batcher.Write(newBatchPoints(t, 2))
+	}()
+	select {
+	case err := <-written:
+		output.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		output.Unlock()
+		t.Fatalf("Write blocked by the flush of the full batch")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(output.writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if writes := output.writes(); len(writes) != 1 || writes[0] != 2 {
+		t.Fatalf("Full batch not flushed: %v", writes)
+	}
+	if err := batcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatcherBufferLimit(t *testing.T) {
+	output := &fakeOutput{Down: true}
+	batcher := &Batcher{Output: output, BatchSize: 2, BufferLimit: 5}
+	for i := 0; i < 4; i++ {
+		if err := batcher.Write(newBatchPoints(t, 2)); err == nil {
+			t.Fatalf("No error with output down")
+		}
+	}
+	// The oldest points are dropped
+	if batcher.Buffered() != 5 || batcher.points[0].Fields()["down"] != int64(1) {
+		t.Fatalf("Invalid buffer: %d", batcher.Buffered())
+	}
+	output.Down = false
+	if err := batcher.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := output.writes(); len(writes) != 3 || batcher.Buffered() != 0 {
+		t.Fatalf("Invalid writes: %v", writes)
+	}
+}
+
+func TestBatcherRejectedPoints(t *testing.T) {
+	output := &fakeOutput{Reject: 3}
+	batcher := &Batcher{Output: output, BatchSize: 4}
+	if err := batcher.Write(newBatchPoints(t, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := batcher.Flush(); err == nil || Transient(err) {
+		t.Fatalf("Invalid error with rejected points: %v", err)
+	}
+	// The rejected points are dropped, and don't block the next ones
+	if writes := output.writes(); len(writes) != 0 || batcher.Buffered() != 0 {
+		t.Fatalf("Rejected points kept: %v %d", writes, batcher.Buffered())
+	}
+	if err := batcher.Write(newBatchPoints(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := batcher.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := output.writes(); len(writes) != 1 || writes[0] != 2 || batcher.Buffered() != 0 {
+		t.Fatalf("Invalid writes: %v %d", writes, batcher.Buffered())
+	}
+}
+
+func TestBatcherRejectedBatch(t *testing.T) {
+	output := &fakeOutput{Reject: 3}
+	batcher := &Batcher{Output: output, BatchSize: 3}
+	batcher.mutex.Lock()
+	batcher.points = append(newBatchPoints(t, 3), newBatchPoints(t, 2)...)
+	batcher.mutex.Unlock()
+	// The batches after the rejected one are written
+	if err := batcher.Flush(); err == nil {
+		t.Fatalf("No error with rejected points")
+	}
+	if writes := output.writes(); len(writes) != 1 || writes[0] != 2 || batcher.Buffered() != 0 {
+		t.Fatalf("Invalid writes: %v %d", writes, batcher.Buffered())
+	}
+}
+
+func TestBatcherPartialWrite(t *testing.T) {
+	output := &fakeOutput{Down: true}
+	batcher := &Batcher{Output: output, BatchSize: 2}
+	points := newBatchPoints(t, 4)
+	batcher.points = points
+	output.Partial = points[1:2]
+	if err := batcher.Flush(); err == nil {
+		t.Fatalf("No error with a partial write")
+	}
+	// The failed point is kept with the points of the next batches
+	if batcher.Buffered() != 3 || batcher.points[0] != points[1] || batcher.points[1] != points[2] {
+		t.Fatalf("Invalid buffer: %d", batcher.Buffered())
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/version"
+)
+
+// indexDateFormat is the date suffix of the daily indices
+const indexDateFormat = "2006.01.02"
+
+func init() {
+	outputs.Add("elasticsearch", func() outputs.Output {
+		return New()
+	})
+}
+
+// bulkResponse is the response of the _bulk API
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Elasticsearch writes the metrics as documents into daily indices, using
+// the Elasticsearch or OpenSearch _bulk API
+type Elasticsearch struct {
+	URL         string
+	Username    string
+	Password    string
+	IndexPrefix string
+	UserAgent   string
+	HTTPClient  *http.Client
+}
+
+// New returns a Elasticsearch Client
+func New() *Elasticsearch {
+	return &Elasticsearch{
+		UserAgent: fmt.Sprintf("skybox-elasticsearch-%s", version.Version),
+	}
+}
+
+func (e *Elasticsearch) Setup(config *config.Configuration) error {
+	if config.Elasticsearch == nil {
+		return fmt.Errorf("Elasticsearch configuration not found: %v", config)
+	}
+	if config.Elasticsearch.IndexPrefix == "" {
+		return fmt.Errorf("Elasticsearch index prefix not found: %v", config.Elasticsearch)
+	}
+	e.URL = strings.TrimSuffix(config.Elasticsearch.URL, "/")
+	e.Username = config.Elasticsearch.Username
+	e.Password = config.Elasticsearch.Password
+	e.IndexPrefix = config.Elasticsearch.IndexPrefix
+	log.Printf("[DEBUG] Elasticsearch output: %s %s", e.URL, e.IndexPrefix)
+	return nil
+}
+
+// Connect creates or updates the index template of the indices
+func (e *Elasticsearch) Connect() error {
+	log.Printf("[DEBUG] Elasticsearch Connect: %s", e.URL)
+	e.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	template, err := json.Marshal(e.indexTemplate())
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Elasticsearch Put index template: %s", e.IndexPrefix)
+	_, err = e.do("PUT", "/_index_template/"+e.IndexPrefix, "application/json", template)
+	return err
+}
+
+func (e *Elasticsearch) Close() error {
+	return nil
+}
+
+func (e *Elasticsearch) Ping() error {
+	if e.HTTPClient == nil {
+		return fmt.Errorf("Elasticsearch Client not configured")
+	}
+	body, err := e.do("GET", "/", "", nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Elasticsearch Ping response: %s", body)
+	return nil
+}
+
+func (e *Elasticsearch) Description() string {
+	return "Configuration for Elasticsearch or OpenSearch server to send metrics to"
+}
+
+// Write indexes a document by point
+func (e *Elasticsearch) Write(points []*client.Point) error {
+	if e.HTTPClient == nil {
+		return fmt.Errorf("Elasticsearch Client not configured")
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, point := range points {
+		action := map[string]interface{}{
+			"index": map[string]string{"_index": e.index(point.Time())},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(document(point)); err != nil {
+			return err
+		}
+	}
+	body, err := e.do("POST", "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	var resp bulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Errors {
+		return bulkError(points, &resp)
+	}
+	log.Printf("[DEBUG] Elasticsearch Write %d documents", len(points))
+	return nil
+}
+
+// bulkError returns the error of the failed documents of a bulk request.
+// The documents rejected by a throttling or a server error are returned in
+// an outputs.PartialWriteError, so they are written again without the
+// indexed ones. The others are invalid, and dropped.
+func bulkError(points []*client.Point, resp *bulkResponse) error {
+	var failed []*client.Point
+	var transient, rejected error
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			err := &outputs.StatusError{
+				Output:     "Elasticsearch bulk",
+				StatusCode: result.Status,
+				Message:    result.Error.Type + ": " + result.Error.Reason,
+			}
+			if err.Temporary() && i < len(points) {
+				failed = append(failed, points[i])
+				transient = err
+			} else {
+				log.Printf("[WARN] Elasticsearch document rejected: %s", err.Error())
+				rejected = err
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return &outputs.PartialWriteError{Err: transient, Failed: failed}
+	}
+	return rejected
+}
+
+// index returns the daily index of the time
+func (e *Elasticsearch) index(t time.Time) string {
+	return fmt.Sprintf("%s-%s", e.IndexPrefix, t.UTC().Format(indexDateFormat))
+}
+
+// indexTemplate returns the composable index template of the indices.
+// Strings are keywords, the point fields are under the measurement name.
+func (e *Elasticsearch) indexTemplate() map[string]interface{} {
+	return map[string]interface{}{
+		"index_patterns": []string{e.IndexPrefix + "-*"},
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"number_of_shards": 1,
+			},
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{
+						"strings": map[string]interface{}{
+							"match_mapping_type": "string",
+							"mapping":            map[string]string{"type": "keyword"},
+						},
+					},
+				},
+				"properties": map[string]interface{}{
+					"@timestamp":  map[string]string{"type": "date"},
+					"measurement": map[string]string{"type": "keyword"},
+					"tags":        map[string]string{"type": "object"},
+				},
+			},
+		},
+	}
+}
+
+// document returns the document of a point: the fields are under the
+// measurement name, like {"measurement":"rate","rate":{"down":1000}}
+func document(point *client.Point) map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp":  point.Time().UTC().Format(time.RFC3339Nano),
+		"measurement": point.Name(),
+		"tags":        point.Tags(),
+		point.Name():  point.Fields(),
+	}
+}
+
+func (e *Elasticsearch) do(method string, path string, contentType string, data []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, e.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", e.UserAgent)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if e.Username != "" {
+		req.SetBasicAuth(e.Username, e.Password)
+	}
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &outputs.StatusError{Output: "Elasticsearch", StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
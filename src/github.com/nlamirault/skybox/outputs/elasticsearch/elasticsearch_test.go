@@ -0,0 +1,152 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+type fakeElasticsearch struct {
+	Template  map[string]interface{}
+	Documents []map[string]interface{}
+	Indices   []string
+	// Reject makes the bulk requests fail
+	Reject bool
+	// Throttle rejects the second document of the bulk requests with 429
+	Throttle bool
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "skybox" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/":
+		fmt.Fprintln(w, `{"name":"node-1","version":{"distribution":"opensearch","number":"2.11.0"}}`)
+	case r.Method == "PUT" && r.URL.Path == "/_index_template/skybox":
+		json.NewDecoder(r.Body).Decode(&f.Template)
+		fmt.Fprintln(w, `{"acknowledged":true}`)
+	case r.Method == "POST" && r.URL.Path == "/_bulk":
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			json.Unmarshal(scanner.Bytes(), &action)
+			f.Indices = append(f.Indices, action["index"]["_index"])
+			scanner.Scan()
+			var document map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &document)
+			f.Documents = append(f.Documents, document)
+		}
+		if f.Throttle {
+			fmt.Fprintln(w, `{"errors":true,"items":[{"index":{"status":201}},`+
+				`{"index":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"}}}]}`)
+			return
+		}
+		if f.Reject {
+			fmt.Fprintln(w, `{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [rate.down]"}}}]}`)
+			return
+		}
+		fmt.Fprintln(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		ioutil.ReadAll(r.Body)
+	}
+}
+
+func newElasticsearch(t *testing.T, url string) *Elasticsearch {
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.Elasticsearch.URL = url
+		conf.Elasticsearch.Username = "skybox"
+		conf.Elasticsearch.Password = "secret"
+	})
+	return output
+}
+
+func newPoints(t *testing.T) []*client.Point {
+	return outputstest.Rates(t,
+		time.Date(2016, 2, 1, 23, 0, 0, 0, time.FixedZone("CET", -3600)), "freebox")
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output := newElasticsearch(t, server.URL+"/")
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if patterns := fake.Template["index_patterns"].([]interface{}); patterns[0] != "skybox-*" {
+		t.Fatalf("Invalid index template: %v", fake.Template)
+	}
+
+	if err := output.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Indices are suffixed with the UTC date
+	if len(fake.Indices) != 1 || fake.Indices[0] != "skybox-2016.02.02" {
+		t.Fatalf("Invalid indices: %v", fake.Indices)
+	}
+	document := fake.Documents[0]
+	rate := document["rate"].(map[string]interface{})
+	if document["@timestamp"] != "2016-02-02T00:00:00Z" || document["measurement"] != "rate" ||
+		rate["down"] != 1000.0 {
+		t.Fatalf("Invalid document: %v", document)
+	}
+}
+
+func TestElasticsearchBulkErrors(t *testing.T) {
+	fake := &fakeElasticsearch{Reject: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output := newElasticsearch(t, server.URL)
+	err := output.Write(newPoints(t))
+	if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("Invalid bulk error: %v", err)
+	}
+}
+
+func TestElasticsearchBulkThrottled(t *testing.T) {
+	fake := &fakeElasticsearch{Throttle: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output := newElasticsearch(t, server.URL)
+	points := append(newPoints(t), newPoints(t)...)
+	err := output.Write(points)
+	// Only the throttled document is written again
+	partial, ok := err.(*outputs.PartialWriteError)
+	if !ok || !outputs.Transient(err) || len(partial.Failed) != 1 || partial.Failed[0] != points[1] {
+		t.Fatalf("Invalid bulk error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "es_rejected_execution_exception") {
+		t.Fatalf("Invalid bulk error message: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// Record is the JSON encoding of a point
+type Record struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// NewRecord returns the record of a point
+func NewRecord(point *client.Point) Record {
+	return Record{
+		Measurement: point.Name(),
+		Tags:        point.Tags(),
+		Fields:      point.Fields(),
+		Time:        point.Time(),
+	}
+}
+
+// ValidFormat checks a payload format of EncodePoints
+func ValidFormat(format string) error {
+	switch format {
+	case "json", "line":
+		return nil
+	}
+	return fmt.Errorf("Unsupported payload format: %s", format)
+}
+
+// EncodePoints returns the points as a JSON array of records, or in the
+// InfluxDB line protocol
+func EncodePoints(points []*client.Point, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		records := make([]Record, 0, len(points))
+		for _, point := range points {
+			records = append(records, NewRecord(point))
+		}
+		return json.Marshal(records)
+	case "line":
+		var buf bytes.Buffer
+		for _, point := range points {
+			buf.WriteString(point.String())
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ValidFormat(format)
+}
+
+// PointsByTag groups the points by the value of a tag. The values are in
+// order of appearance.
+func PointsByTag(points []*client.Point, tag string) ([]string, map[string][]*client.Point) {
+	var values []string
+	groups := map[string][]*client.Point{}
+	for _, point := range points {
+		value := point.Tags()[tag]
+		if _, ok := groups[value]; !ok {
+			values = append(values, value)
+		}
+		groups[value] = append(groups[value], point)
+	}
+	return values, groups
+}
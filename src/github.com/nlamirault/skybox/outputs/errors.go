@@ -0,0 +1,90 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// temporary is implemented by the errors which know if a write could
+// succeed later
+type temporary interface {
+	Temporary() bool
+}
+
+// StatusError is an error response of an HTTP output
+type StatusError struct {
+	Output     string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s error %d: %s", e.Output, e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the server could accept the write later:
+// throttling and server errors
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// PartialWriteError is the error of a write which failed for some points
+// only: the other points are written, and must not be written again
+type PartialWriteError struct {
+	Err error
+	// Failed are the points which could be written again
+	Failed []*client.Point
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%s (%d points not written)", e.Err.Error(), len(e.Failed))
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether a failed write could succeed later, so its
+// points should be kept. Connection errors and timeouts are transient, the
+// errors implementing Temporary decide, and the others reject the points.
+func Transient(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var tempErr temporary
+	if errors.As(err, &tempErr) {
+		return tempErr.Temporary()
+	}
+	return false
+}
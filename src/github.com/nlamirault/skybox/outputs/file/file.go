@@ -0,0 +1,283 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+const (
+	jsonlFormat = "jsonl"
+	csvFormat   = "csv"
+)
+
+var csvHeader = []string{"time", "measurement", "tags", "field", "value"}
+
+func init() {
+	outputs.Add("file", func() outputs.Output {
+		return New()
+	})
+}
+
+// File writes the points into a file, rotated by size and time
+type File struct {
+	Path   string
+	Format string
+	// MaxSize is the size of the file which triggers a rotation
+	MaxSize int64
+	// RotateInterval is the age of the file which triggers a rotation
+	RotateInterval time.Duration
+	Gzip           bool
+	// Now returns the current time
+	Now func() time.Time
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// New returns a File output
+func New() *File {
+	return &File{
+		Format: jsonlFormat,
+		Now:    time.Now,
+	}
+}
+
+func (f *File) Setup(config *config.Configuration) error {
+	if config.File == nil || config.File.Path == "" {
+		return fmt.Errorf("File configuration not found: %v", config)
+	}
+	f.Path = config.File.Path
+	if config.File.Format != "" {
+		f.Format = config.File.Format
+	}
+	if f.Format != jsonlFormat && f.Format != csvFormat {
+		return fmt.Errorf("File invalid format: %s", f.Format)
+	}
+	f.MaxSize = config.File.MaxSize
+	f.RotateInterval = time.Duration(config.File.RotateInterval) * time.Second
+	f.Gzip = config.File.Gzip
+	log.Printf("[DEBUG] File output: %v", f)
+	return nil
+}
+
+func (f *File) Connect() error {
+	log.Printf("[DEBUG] File open: %s", f.Path)
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.Now()
+	if f.size == 0 && f.Format == csvFormat {
+		return f.write(encodeCSV([][]string{csvHeader}))
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *File) Ping() error {
+	if f.file == nil {
+		return fmt.Errorf("File not opened")
+	}
+	_, err := f.file.Stat()
+	return err
+}
+
+func (f *File) Description() string {
+	return "Configuration for file to write metrics to"
+}
+
+func (f *File) Write(points []*client.Point) error {
+	if f.file == nil {
+		return fmt.Errorf("File not opened")
+	}
+	var data []byte
+	var err error
+	if f.Format == csvFormat {
+		data = encodeCSV(csvRecords(points))
+	} else {
+		data, err = encodeJSONL(points)
+		if err != nil {
+			return err
+		}
+	}
+	if f.rotationNeeded(int64(len(data))) {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return err
+			}
+			log.Printf("[WARN] File rotation failed, writing into %s: %s", f.Path, err.Error())
+		}
+	}
+	log.Printf("[DEBUG] File Write %d points", len(points))
+	return f.write(data)
+}
+
+func (f *File) write(data []byte) error {
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return err
+}
+
+func (f *File) rotationNeeded(size int64) bool {
+	if f.MaxSize > 0 && f.size > 0 && f.size+size > f.MaxSize {
+		return true
+	}
+	if f.RotateInterval > 0 && f.Now().Sub(f.opened) >= f.RotateInterval {
+		return true
+	}
+	return false
+}
+
+// rotate renames the file with its rotation time, compress it if needed,
+// and opens a new file. The current file is opened again if the rotation
+// fails.
+func (f *File) rotate() error {
+	rotated := f.rotatedPath()
+	err := f.Close()
+	if err == nil {
+		log.Printf("[INFO] File rotation: %s", rotated)
+		err = os.Rename(f.Path, rotated)
+	}
+	if err != nil {
+		if openErr := f.Connect(); openErr != nil {
+			return fmt.Errorf("File rotation failed: %s, can't open %s: %s",
+				err.Error(), f.Path, openErr.Error())
+		}
+		return err
+	}
+	if f.Gzip {
+		if err := compress(rotated); err != nil {
+			log.Printf("[WARN] Can't compress rotated file %s: %s", rotated, err.Error())
+		}
+	}
+	return f.Connect()
+}
+
+// rotatedPath returns the path of the file with its rotation time. A
+// sequence number is added if a file was already rotated in this second.
+func (f *File) rotatedPath() string {
+	ext := filepath.Ext(f.Path)
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(f.Path, ext), f.Now().Format("20060102T150405"))
+	rotated := base + ext
+	for seq := 1; exists(rotated) || exists(rotated+".gz"); seq++ {
+		rotated = fmt.Sprintf("%s.%d%s", base, seq, ext)
+	}
+	return rotated
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// compress replaces the file by its gzip version
+func compress(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func encodeJSONL(points []*client.Point) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, point := range points {
+		record := outputs.NewRecord(point)
+		record.Time = record.Time.UTC()
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// csvRecords returns a line by field, the tags are formatted as k=v;k=v
+func csvRecords(points []*client.Point) [][]string {
+	var records [][]string
+	for _, point := range points {
+		tags := outputs.FormatTags(point.Tags())
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			records = append(records, []string{
+				point.Time().UTC().Format(time.RFC3339),
+				point.Name(),
+				tags,
+				name,
+				fmt.Sprintf("%v", fields[name]),
+			})
+		}
+	}
+	return records
+}
+
+func encodeCSV(records [][]string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(records)
+	return buf.Bytes()
+}
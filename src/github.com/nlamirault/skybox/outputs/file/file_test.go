@@ -0,0 +1,178 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newFile(t *testing.T, setup func(*config.FileConfiguration)) (*File, string, *time.Time) {
+	dir, err := ioutil.TempDir("", "skybox-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New()
+	outputstest.Setup(t, f, func(conf *config.Configuration) {
+		conf.File.Path = filepath.Join(dir, "metrics.jsonl")
+		setup(conf.File)
+	})
+	now := time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC)
+	f.Now = func() time.Time { return now }
+	if err := f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return f, dir, &now
+}
+
+func TestFileJSONL(t *testing.T) {
+	f, dir, now := newFile(t, func(conf *config.FileConfiguration) {})
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	if err := f.Write(outputstest.Rates(t, *now, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r outputs.Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("Invalid JSON line: %s", data)
+	}
+	if r.Measurement != "rate" || r.Tags["box"] != "freebox" ||
+		r.Fields["down"] != 1000.0 || !r.Time.Equal(*now) {
+		t.Fatalf("Invalid record: %v", r)
+	}
+}
+
+func TestFileCSV(t *testing.T) {
+	f, dir, now := newFile(t, func(conf *config.FileConfiguration) {
+		conf.Path = strings.Replace(conf.Path, ".jsonl", ".csv", 1)
+		conf.Format = "csv"
+	})
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	pt := outputstest.Point(t, "rate",
+		map[string]string{"rate": "rate-up-down", "box": "freebox"},
+		map[string]interface{}{"up": 200, "down": 1000}, *now)
+	if err := f.Write([]*client.Point{pt}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "time,measurement,tags,field,value\n" +
+		"2016-02-01T12:00:00Z,rate,box=freebox;rate=rate-up-down,down,1000\n" +
+		"2016-02-01T12:00:00Z,rate,box=freebox;rate=rate-up-down,up,200\n"
+	if string(data) != expected {
+		t.Fatalf("Invalid CSV file: %s", data)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	f, dir, now := newFile(t, func(conf *config.FileConfiguration) {
+		conf.MaxSize = 150
+		conf.RotateInterval = 3600
+		conf.Gzip = true
+	})
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	// Size rotation on the second write
+	f.Write(outputstest.Rates(t, *now, "freebox"))
+	*now = now.Add(time.Second)
+	f.Write(outputstest.Rates(t, *now, "freebox"))
+	// Time rotation
+	*now = now.Add(time.Hour)
+	f.Write(outputstest.Rates(t, *now, "freebox"))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "metrics-*.jsonl.gz"))
+	if len(files) != 2 || filepath.Base(files[0]) != "metrics-20160201T120001.jsonl.gz" {
+		t.Fatalf("Invalid rotated files: %v", files)
+	}
+	gz, err := os.Open(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	reader, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("Invalid rotated file: %s %v", data, err)
+	}
+}
+
+func TestFileRotationSameSecond(t *testing.T) {
+	f, dir, now := newFile(t, func(conf *config.FileConfiguration) {
+		conf.MaxSize = 100
+	})
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := f.Write(outputstest.Rates(t, *now, "freebox")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "metrics-*.jsonl"))
+	sort.Strings(files)
+	if len(files) != 3 || filepath.Base(files[0]) != "metrics-20160201T120000.1.jsonl" ||
+		filepath.Base(files[2]) != "metrics-20160201T120000.jsonl" {
+		t.Fatalf("Invalid rotated files: %v", files)
+	}
+}
+
+func TestFileRotationFailure(t *testing.T) {
+	f, dir, now := newFile(t, func(conf *config.FileConfiguration) {
+		conf.MaxSize = 100
+	})
+	defer os.RemoveAll(dir)
+	defer f.Close()
+
+	if err := f.Write(outputstest.Rates(t, *now, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	// The rename of the rotation fails
+	if err := os.Remove(filepath.Join(dir, "metrics.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write(outputstest.Rates(t, *now, "freebox")); err != nil {
+		t.Fatalf("Write failed after a rotation error: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "metrics.jsonl"))
+	if err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Fatalf("Points not written into the current file: %s %v", data, err)
+	}
+}
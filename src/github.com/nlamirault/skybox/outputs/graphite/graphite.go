@@ -0,0 +1,294 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+const (
+	plaintextProtocol = "plaintext"
+	pickleProtocol    = "pickle"
+
+	defaultTimeout = 5 * time.Second
+	// defaultPacketSize fits in an Ethernet MTU with IP and UDP headers
+	defaultPacketSize = 1432
+)
+
+var (
+	templateTag = regexp.MustCompile(`\{([^}]+)\}`)
+
+	invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+)
+
+func init() {
+	outputs.Add("graphite", func() outputs.Output {
+		return New()
+	})
+}
+
+// Graphite sends metrics to Carbon
+type Graphite struct {
+	Address   string
+	Protocol  string
+	Transport string
+	Prefix    string
+	Template  string
+	Timeout   time.Duration
+	// PacketSize is the maximum size of the UDP packets
+	PacketSize int
+	Conn       net.Conn
+}
+
+// New returns a Graphite Client
+func New() *Graphite {
+	return &Graphite{
+		Protocol:   plaintextProtocol,
+		Transport:  "tcp",
+		Timeout:    defaultTimeout,
+		PacketSize: defaultPacketSize,
+	}
+}
+
+func (g *Graphite) Setup(config *config.Configuration) error {
+	if config.Graphite == nil {
+		return fmt.Errorf("Graphite configuration not found: %v", config)
+	}
+	g.Address = config.Graphite.Address
+	g.Prefix = config.Graphite.Prefix
+	g.Template = config.Graphite.Template
+	if config.Graphite.Protocol != "" {
+		g.Protocol = config.Graphite.Protocol
+	}
+	if config.Graphite.Transport != "" {
+		g.Transport = config.Graphite.Transport
+	}
+	if config.Graphite.PacketSize > 0 {
+		g.PacketSize = config.Graphite.PacketSize
+	}
+	switch g.Protocol {
+	case plaintextProtocol, pickleProtocol:
+	default:
+		return fmt.Errorf("Graphite invalid protocol: %s", g.Protocol)
+	}
+	switch g.Transport {
+	case "tcp":
+	case "udp":
+		if g.Protocol == pickleProtocol {
+			return fmt.Errorf("Graphite pickle protocol requires TCP")
+		}
+	default:
+		return fmt.Errorf("Graphite invalid transport: %s", g.Transport)
+	}
+	log.Printf("[DEBUG] Graphite output: %v", g)
+	return nil
+}
+
+func (g *Graphite) Connect() error {
+	log.Printf("[DEBUG] Graphite Connect: %s://%s", g.Transport, g.Address)
+	conn, err := net.DialTimeout(g.Transport, g.Address, g.Timeout)
+	if err != nil {
+		return err
+	}
+	g.Conn = conn
+	return nil
+}
+
+func (g *Graphite) Close() error {
+	if g.Conn == nil {
+		return nil
+	}
+	err := g.Conn.Close()
+	g.Conn = nil
+	return err
+}
+
+func (g *Graphite) Ping() error {
+	if g.Conn == nil {
+		return fmt.Errorf("Graphite Client not configured")
+	}
+	return nil
+}
+
+func (g *Graphite) Description() string {
+	return "Configuration for Graphite server to send metrics to"
+}
+
+func (g *Graphite) Write(points []*client.Point) error {
+	metrics := g.metrics(points)
+	if len(metrics) == 0 {
+		return nil
+	}
+	var data []byte
+	var err error
+	if g.Protocol == pickleProtocol {
+		data, err = encodePickle(metrics)
+		if err != nil {
+			return err
+		}
+	} else {
+		data = encodePlaintext(metrics)
+	}
+	log.Printf("[DEBUG] Graphite Write %d metrics", len(metrics))
+	if g.Transport == "udp" {
+		for _, packet := range splitLines(data, g.PacketSize) {
+			if _, err := g.send(packet); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n, err := g.send(data)
+	if err != nil {
+		// The connection could be closed by Carbon: connect again once,
+		// and send what wasn't received
+		log.Printf("[DEBUG] Graphite Write failed after %d bytes, reconnect: %s", n, err.Error())
+		g.Close()
+		if err := g.Connect(); err != nil {
+			return err
+		}
+		_, err = g.send(g.unwritten(data, n))
+		return err
+	}
+	return nil
+}
+
+// unwritten returns the data to send again after n bytes were written.
+// Carbon drops the incomplete line or pickle message of a closed
+// connection, so it is sent again.
+func (g *Graphite) unwritten(data []byte, n int) []byte {
+	if n >= len(data) {
+		return nil
+	}
+	if g.Protocol == pickleProtocol {
+		return data
+	}
+	return data[bytes.LastIndexByte(data[:n], '\n')+1:]
+}
+
+// splitLines splits the plaintext lines into packets of at most size
+// bytes. A line longer than size is sent in its own packet.
+func splitLines(data []byte, size int) [][]byte {
+	var packets [][]byte
+	for len(data) > size {
+		end := bytes.LastIndexByte(data[:size], '\n') + 1
+		if end == 0 {
+			end = bytes.IndexByte(data, '\n') + 1
+			if end == 0 {
+				end = len(data)
+			}
+		}
+		packets = append(packets, data[:end])
+		data = data[end:]
+	}
+	if len(data) > 0 {
+		packets = append(packets, data)
+	}
+	return packets
+}
+
+// send writes the data, and returns the number of bytes written
+func (g *Graphite) send(data []byte) (int, error) {
+	if g.Conn == nil {
+		return 0, fmt.Errorf("Graphite Client not configured")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if err := g.Conn.SetWriteDeadline(time.Now().Add(g.Timeout)); err != nil {
+		return 0, err
+	}
+	return g.Conn.Write(data)
+}
+
+// metric is a Graphite metric: a path, a value and a timestamp
+type metric struct {
+	Path      string
+	Value     float64
+	Timestamp int64
+}
+
+// metrics returns a metric for each numeric field of the points
+func (g *Graphite) metrics(points []*client.Point) []metric {
+	var metrics []metric
+	for _, point := range points {
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			value, ok := outputs.FieldFloat(fields[name])
+			if !ok {
+				continue
+			}
+			metrics = append(metrics, metric{
+				Path:      g.path(point, name),
+				Value:     value,
+				Timestamp: point.Time().Unix(),
+			})
+		}
+	}
+	return metrics
+}
+
+// path returns the metric path of a field, using the template.
+// {measurement} and {field} are replaced by the point name and the field
+// name, the others by the point tags.
+func (g *Graphite) path(point *client.Point, field string) string {
+	tags := point.Tags()
+	path := templateTag.ReplaceAllStringFunc(g.Template, func(s string) string {
+		name := s[1 : len(s)-1]
+		var value string
+		switch name {
+		case "measurement":
+			value = point.Name()
+		case "field":
+			value = field
+		default:
+			value = tags[name]
+		}
+		if value == "" {
+			return ""
+		}
+		return invalidChars.ReplaceAllString(value, "_")
+	})
+	if g.Prefix != "" {
+		path = g.Prefix + "." + path
+	}
+	// Remove the empty nodes of missing tags
+	var nodes []string
+	for _, node := range strings.Split(path, ".") {
+		if node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return strings.Join(nodes, ".")
+}
+
+func encodePlaintext(metrics []metric) []byte {
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "%s %v %d\n", m.Path, m.Value, m.Timestamp)
+	}
+	return buf.Bytes()
+}
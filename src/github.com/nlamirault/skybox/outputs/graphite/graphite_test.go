@@ -0,0 +1,232 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newPoints(t *testing.T) []*client.Point {
+	return []*client.Point{outputstest.Point(t, "rate",
+		map[string]string{"rate": "rate-up-down", "box": "freebox"},
+		map[string]interface{}{"up": 200, "down": 1000, "state": "up"},
+		outputstest.Time)}
+}
+
+func newGraphite(t *testing.T, setup func(*config.GraphiteConfiguration)) *Graphite {
+	g := New()
+	outputstest.Setup(t, g, func(conf *config.Configuration) {
+		setup(conf.Graphite)
+	})
+	return g
+}
+
+func TestGraphitePath(t *testing.T) {
+	g := newGraphite(t, func(conf *config.GraphiteConfiguration) {
+		conf.Template = "{box}.{missing}.{measurement}.{field}"
+	})
+	pt := newPoints(t)[0]
+	if path := g.path(pt, "down"); path != "skybox.freebox.rate.down" {
+		t.Fatalf("Invalid path: %s", path)
+	}
+	g.Prefix = ""
+	g.Template = "{rate}.{field}"
+	if path := g.path(pt, "up"); path != "rate-up-down.up" {
+		t.Fatalf("Invalid path: %s", path)
+	}
+}
+
+func TestGraphitePlaintext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	g := newGraphite(t, func(conf *config.GraphiteConfiguration) {
+		conf.Address = listener.Addr().String()
+	})
+	if err := g.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err := g.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"skybox.freebox.rate.down 1000 1454284800",
+		"skybox.freebox.rate.up 200 1454284800",
+	} {
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Fatalf("Invalid line: %s", line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Metric not received: %s", expected)
+		}
+	}
+}
+
+func TestGraphitePickle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	payloads := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		payloads <- payload
+	}()
+
+	g := newGraphite(t, func(conf *config.GraphiteConfiguration) {
+		conf.Address = listener.Addr().String()
+		conf.Protocol = "pickle"
+	})
+	if err := g.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err := g.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-payloads:
+		// 2 metrics: 4 bytes of header, 2 bytes of footer and for each
+		// metric the path, the timestamp, the value and 2 tuples
+		size := 4 + 2 + 2*(5+5+9+2) + len("skybox.freebox.rate.down") + len("skybox.freebox.rate.up")
+		if len(payload) != size || payload[0] != opProto || payload[len(payload)-1] != opStop {
+			t.Fatalf("Invalid pickle payload: %v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Pickle payload not received")
+	}
+}
+
+func TestGraphiteUDPPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	g := newGraphite(t, func(conf *config.GraphiteConfiguration) {
+		conf.Address = conn.LocalAddr().String()
+		conf.Transport = "udp"
+		conf.PacketSize = 60
+	})
+	if err := g.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if err := g.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Each line of 41 bytes is sent in its own packet
+	buf := make([]byte, 2048)
+	for _, expected := range []string{
+		"skybox.freebox.rate.down 1000 1454284800\n",
+		"skybox.freebox.rate.up 200 1454284800\n",
+	} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Packet not received: %v", err)
+		}
+		if string(buf[:n]) != expected {
+			t.Fatalf("Invalid packet: %q", buf[:n])
+		}
+	}
+}
+
+func TestGraphiteSplitLines(t *testing.T) {
+	data := []byte("a.b 1 10\nc.d 2 10\ne.f.g.h 3 10\n")
+	packets := splitLines(data, 18)
+	if len(packets) != 2 || string(packets[0]) != "a.b 1 10\nc.d 2 10\n" ||
+		string(packets[1]) != "e.f.g.h 3 10\n" {
+		t.Fatalf("Invalid packets: %q", packets)
+	}
+	// A line longer than the packet size is sent alone
+	packets = splitLines(data, 10)
+	if len(packets) != 3 || string(packets[2]) != "e.f.g.h 3 10\n" {
+		t.Fatalf("Invalid packets: %q", packets)
+	}
+}
+
+func TestGraphiteUnwritten(t *testing.T) {
+	g := New()
+	data := []byte("a.b 1 10\nc.d 2 10\n")
+	// The lines already written aren't sent again, the incomplete one is
+	for n, expected := range map[int]string{
+		0:  "a.b 1 10\nc.d 2 10\n",
+		9:  "c.d 2 10\n",
+		12: "c.d 2 10\n",
+		18: "",
+	} {
+		if tail := g.unwritten(data, n); string(tail) != expected {
+			t.Fatalf("Invalid data to send again after %d bytes: %q", n, tail)
+		}
+	}
+	g.Protocol = pickleProtocol
+	if tail := g.unwritten(data, 12); string(tail) != string(data) {
+		t.Fatalf("Incomplete pickle message not sent again: %q", tail)
+	}
+}
+
+func TestGraphitePickleOverUDP(t *testing.T) {
+	conf := config.New()
+	conf.Graphite.Protocol = "pickle"
+	conf.Graphite.Transport = "udp"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with pickle over UDP")
+	}
+}
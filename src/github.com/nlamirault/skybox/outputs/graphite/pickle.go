@@ -0,0 +1,61 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Pickle protocol 2 opcodes
+const (
+	opProto      = 0x80
+	opEmptyList  = ']'
+	opMark       = '('
+	opAppends    = 'e'
+	opBinUnicode = 'X'
+	opBinInt     = 'J'
+	opBinFloat   = 'G'
+	opTuple2     = 0x86
+	opStop       = '.'
+)
+
+// encodePickle encodes the metrics as expected by the Carbon pickle
+// receiver: a 4 bytes big endian length header followed by the pickled
+// list [(path, (timestamp, value)), ...]
+func encodePickle(metrics []metric) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{opProto, 2, opEmptyList, opMark})
+	for _, m := range metrics {
+		if m.Timestamp < math.MinInt32 || m.Timestamp > math.MaxInt32 {
+			return nil, fmt.Errorf("Graphite invalid timestamp: %d", m.Timestamp)
+		}
+		buf.WriteByte(opBinUnicode)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(m.Path)))
+		buf.WriteString(m.Path)
+		buf.WriteByte(opBinInt)
+		binary.Write(&buf, binary.LittleEndian, int32(m.Timestamp))
+		buf.WriteByte(opBinFloat)
+		binary.Write(&buf, binary.BigEndian, m.Value)
+		buf.Write([]byte{opTuple2, opTuple2})
+	}
+	buf.Write([]byte{opAppends, opStop})
+
+	payload := make([]byte, 4, 4+buf.Len())
+	binary.BigEndian.PutUint32(payload, uint32(buf.Len()))
+	return append(payload, buf.Bytes()...), nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// droppedPoints is the number of points rejected by a partial write
+var droppedPoints = regexp.MustCompile(`dropped=(\d+)`)
+
+// apiError is the error returned by the InfluxDB 1.x and 2.x APIs
+type apiError struct {
+	// Error is the message of the 1.x API
+	Error string `json:"error"`
+	// Code and Message are the error of the 2.x API
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError is an error response of the InfluxDB server
+type writeError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay requested by the server before a retry
+	RetryAfter time.Duration
+}
+
+func (e *writeError) Error() string {
+	return fmt.Sprintf("InfluxDB error %d: %s", e.StatusCode, e.Message)
+}
+
+// Partial reports whether some points were written: the others are
+// invalid, so the write must not be retried
+func (e *writeError) Partial() bool {
+	return strings.Contains(e.Message, "partial write")
+}
+
+// Dropped returns the number of points rejected by a partial write, or -1
+// if unknown
+func (e *writeError) Dropped() int {
+	match := droppedPoints.FindStringSubmatch(e.Message)
+	if match == nil {
+		return -1
+	}
+	n, _ := strconv.Atoi(match[1])
+	return n
+}
+
+// newWriteError reads the error message of a response
+func newWriteError(resp *http.Response, body []byte) *writeError {
+	message := strings.TrimSpace(string(body))
+	var apiErr apiError
+	if err := json.Unmarshal(body, &apiErr); err == nil {
+		if apiErr.Error != "" {
+			message = apiErr.Error
+		} else if apiErr.Message != "" {
+			message = apiErr.Code + ": " + apiErr.Message
+		}
+	}
+	e := &writeError{StatusCode: resp.StatusCode, Message: message}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// Temporary reports whether the server could accept the write later:
+// throttling and server errors. The other responses reject the data or the
+// credentials, which would fail again.
+func (e *writeError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
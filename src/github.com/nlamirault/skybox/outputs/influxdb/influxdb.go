@@ -17,6 +17,9 @@ package influxdb
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
 
@@ -32,6 +35,17 @@ func init() {
 
 }
 
+// minRetryDelay is the shortest delay before a retry: without delay, the
+// retries of all the writes would hammer a failing server
+const minRetryDelay = time.Second
+
+// writer writes points to an InfluxDB server
+type writer interface {
+	Ping() error
+	Write(points []*client.Point, conf client.BatchPointsConfig) error
+	Close() error
+}
+
 type InfluxDB struct {
 	URL       string
 	URLs      []string `toml:"urls"`
@@ -39,17 +53,55 @@ type InfluxDB struct {
 	Password  string
 	Database  string
 	UserAgent string
-	Client    client.Client
+
+	RetentionPolicy string
+	// RetentionDuration is the InfluxQL duration of the retention policy
+	RetentionDuration string
+	WriteConsistency  string
 
 	Precision  string
 	UDPPayload int `toml:"udp_payload"`
+
+	// Version is the InfluxDB API version. 2 and 3 use the /api/v2/write
+	// endpoint with token authentication.
+	Version      int
+	Token        string
+	Organization string
+	Bucket       string
+	Gzip         bool
+
+	// RoundRobin spreads the writes across the URLs. Otherwise the same
+	// URL is used until it fails.
+	RoundRobin bool
+
+	// Retries is the number of retries of the transient write errors
+	Retries int
+	// RetryDelay is the delay before the first retry, at least a second,
+	// doubled at each retry up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// BoxTag keeps the box tag of the points
+	BoxTag bool
+
+	writers []writer
+	// current is the index of the next writer to use
+	current int
+	// interruption aborts the waits between the retries
+	interruption *outputs.Interruption
+	sleep        func(time.Duration)
 }
 
 // New returns a InfluxDB Client
 func New() *InfluxDB {
-	return &InfluxDB{
-		UserAgent: fmt.Sprintf("skybox-influxdb-%s", version.Version),
+	i := &InfluxDB{
+		UserAgent:    fmt.Sprintf("skybox-influxdb-%s", version.Version),
+		Precision:    "s",
+		interruption: outputs.NewInterruption(),
+	}
+	i.sleep = func(d time.Duration) {
+		i.interruption.Wait(d)
 	}
+	return i
 }
 
 func (i *InfluxDB) Setup(config *config.Configuration) error {
@@ -57,72 +109,297 @@ func (i *InfluxDB) Setup(config *config.Configuration) error {
 		return fmt.Errorf("InfluxDB configuration not found: %v", config)
 	}
 	i.URL = config.InfluxDB.URL
+	i.URLs = config.InfluxDB.URLs
+	if len(i.URLs) == 0 {
+		i.URLs = []string{i.URL}
+	}
+	i.UDPPayload = config.InfluxDB.UDPPayload
+	i.RoundRobin = config.InfluxDB.RoundRobin
 	i.Username = config.InfluxDB.Username
 	i.Password = config.InfluxDB.Password
 	i.Database = config.InfluxDB.Database
-	log.Printf("[DEBUG] InfluxDB output: %v", i)
+	i.RetentionPolicy = config.InfluxDB.RetentionPolicy
+	i.RetentionDuration = config.InfluxDB.RetentionDuration
+	i.WriteConsistency = config.InfluxDB.WriteConsistency
+	if config.InfluxDB.Precision != "" {
+		i.Precision = config.InfluxDB.Precision
+	}
+	i.Version = config.InfluxDB.Version
+	i.Token = config.InfluxDB.Token
+	i.Organization = config.InfluxDB.Organization
+	i.Bucket = config.InfluxDB.Bucket
+	i.Gzip = config.InfluxDB.Gzip
+	i.Retries = config.InfluxDB.Retries
+	i.RetryDelay = time.Duration(config.InfluxDB.RetryDelay) * time.Second
+	if i.RetryDelay < minRetryDelay {
+		i.RetryDelay = minRetryDelay
+	}
+	i.MaxRetryDelay = time.Duration(config.InfluxDB.MaxRetryDelay) * time.Second
+	i.BoxTag = config.InfluxDB.BoxTag
+	switch i.Version {
+	case 0, 1:
+		i.Version = 1
+	case 2, 3:
+		if i.Bucket == "" {
+			return fmt.Errorf("InfluxDB bucket not found: %v", config.InfluxDB)
+		}
+	default:
+		return fmt.Errorf("InfluxDB unsupported version: %d", i.Version)
+	}
+	if !validPrecision(i.Precision, i.Version) {
+		return fmt.Errorf("InfluxDB invalid precision: %s", i.Precision)
+	}
+	switch i.WriteConsistency {
+	case "", "any", "one", "quorum", "all":
+	default:
+		return fmt.Errorf("InfluxDB invalid write consistency: %s", i.WriteConsistency)
+	}
+	if i.RetentionDuration != "" {
+		if _, err := parseDuration(i.RetentionDuration); err != nil {
+			return fmt.Errorf("InfluxDB invalid retention duration: %s", i.RetentionDuration)
+		}
+	}
+	for _, u := range i.URLs {
+		endpoint, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("InfluxDB invalid URL: %s", u)
+		}
+		if endpoint.Scheme == "udp" && i.Version > 1 {
+			return fmt.Errorf("InfluxDB UDP requires the version 1: %s", u)
+		}
+	}
+	log.Printf("[DEBUG] InfluxDB output: %v %d", i.URLs, i.Version)
 	return nil
 }
 
 func (i *InfluxDB) Ping() error {
-	if i.Client == nil {
+	if len(i.writers) == 0 {
 		return fmt.Errorf("InfluxDB Client not configured")
 	}
-	resp, err := i.Client.Query(client.Query{
-		Command: fmt.Sprintf("SHOW DATABASES"),
-	})
-	if err != nil {
-		return err
+	var err error
+	for _, w := range i.writers {
+		if err = w.Ping(); err == nil {
+			return nil
+		}
 	}
-	log.Printf("[DEBUG] InfluxDB Check database response: %v", resp)
-	return nil
+	return err
 }
 
+// Connect creates a client for each URL. It fails only if all the InfluxDB
+// servers are unavailable.
 func (i *InfluxDB) Connect() error {
-	log.Printf("[DEBUG] InfluxDB Connect: %v", i)
+	var writers []writer
+	var lastErr error
+	available := 0
+	for _, u := range i.URLs {
+		log.Printf("[DEBUG] InfluxDB Connect: %s", u)
+		w, err := i.newWriter(u)
+		if err != nil {
+			return err
+		}
+		writers = append(writers, w)
+		if err := i.setupServer(w); err != nil {
+			log.Printf("[WARN] InfluxDB server unavailable %s: %s", u, err.Error())
+			lastErr = err
+			continue
+		}
+		available++
+	}
+	if available == 0 {
+		for _, w := range writers {
+			w.Close()
+		}
+		return lastErr
+	}
+	i.writers = writers
+	i.current = 0
+	return nil
+}
+
+func (i *InfluxDB) newWriter(u string) (writer, error) {
+	endpoint, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if i.Version > 1 {
+		return newV2Client(i, u), nil
+	}
+	if endpoint.Scheme == "udp" {
+		c, err := client.NewUDPClient(client.UDPConfig{
+			Addr:        endpoint.Host,
+			PayloadSize: i.UDPPayload,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &v1Client{URL: u, Client: c, UDP: true}, nil
+	}
 	c, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr:      i.URL,
+		Addr:      u,
 		Username:  i.Username,
 		Password:  i.Password,
 		UserAgent: i.UserAgent,
 		//Timeout:   5,
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &v1Client{
+		URL:        u,
+		Client:     c,
+		Username:   i.Username,
+		Password:   i.Password,
+		UserAgent:  i.UserAgent,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// setupServer creates the database if it doesn't exist
+func (i *InfluxDB) setupServer(w writer) error {
+	v1, ok := w.(*v1Client)
+	if !ok {
+		return w.Ping()
+	}
+	if v1.UDP {
+		return nil
 	}
-	// Create Database if it doesn't exist
 	log.Printf("[DEBUG] InfluxDB Create database if not exists")
-	resp, err := c.Query(client.Query{
+	resp, err := v1.Client.Query(client.Query{
 		Command: fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", i.Database),
 	})
 	if err != nil {
 		return err
 	}
 	log.Printf("[DEBUG] InfluxDB connect response: %v", resp)
-	i.Client = c
+	if i.RetentionPolicy != "" {
+		return setupRetentionPolicy(v1.Client, i.Database, i.RetentionPolicy, i.RetentionDuration)
+	}
 	return nil
 }
 
+// validPrecision checks the precision is supported by the API version
+func validPrecision(precision string, version int) bool {
+	switch precision {
+	case "ns", "ms", "s":
+		return true
+	case "m", "h":
+		return version == 1
+	case "us":
+		return version > 1
+	}
+	return false
+}
+
 func (i *InfluxDB) Close() error {
-	return nil
+	var err error
+	for _, w := range i.writers {
+		if e := w.Close(); e != nil {
+			err = e
+		}
+	}
+	i.writers = nil
+	return err
 }
 
 func (i *InfluxDB) Description() string {
 	return "Configuration for InfluxDB server to send metrics to"
 }
 
+// Write sends the points, and retries the transient errors with an
+// exponential backoff, until interrupted. Invalid points or credentials aren't retried: a
+// partial write is reported with the number of rejected points.
 func (i *InfluxDB) Write(points []*client.Point) error {
-	log.Printf("[DEBUG] InfluxDB Make points")
-	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  i.Database,
-		Precision: "s",
-	})
+	if len(i.writers) == 0 {
+		return fmt.Errorf("InfluxDB Client not configured")
+	}
+	if !i.BoxTag {
+		var err error
+		if points, err = withoutTag(points, "box"); err != nil {
+			return err
+		}
+	}
+	delay := i.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := i.write(points)
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(*writeError); ok && e.Partial() {
+			log.Printf("[WARN] InfluxDB partial write, %d points dropped: %s", e.Dropped(), e.Message)
+			return err
+		}
+		if !outputs.Transient(err) || attempt >= i.Retries || i.interruption.Interrupted() {
+			return err
+		}
+		wait := delay
+		if e, ok := err.(*writeError); ok && e.RetryAfter > wait {
+			wait = e.RetryAfter
+		}
+		if i.MaxRetryDelay > 0 && wait > i.MaxRetryDelay {
+			wait = i.MaxRetryDelay
+		}
+		log.Printf("[WARN] InfluxDB write retry %d/%d in %s: %s", attempt+1, i.Retries, wait, err.Error())
+		i.sleep(wait)
+		if i.interruption.Interrupted() {
+			return err
+		}
+		delay *= 2
+	}
+}
 
+// Interrupt aborts the wait before the next retry, and the next retries
+func (i *InfluxDB) Interrupt() {
+	i.interruption.Interrupt()
+}
+
+// write sends the points to the current server, and fails over to the
+// next ones on transient errors
+func (i *InfluxDB) write(points []*client.Point) error {
+	conf := client.BatchPointsConfig{
+		Database:         i.Database,
+		RetentionPolicy:  i.RetentionPolicy,
+		Precision:        i.Precision,
+		WriteConsistency: i.WriteConsistency,
+	}
+	var err error
+	for n := 0; n < len(i.writers); n++ {
+		index := (i.current + n) % len(i.writers)
+		if err = i.writers[index].Write(points, conf); err != nil {
+			log.Printf("[WARN] InfluxDB write failed on %s: %s", i.URLs[index], err.Error())
+			if !outputs.Transient(err) {
+				return err
+			}
+			continue
+		}
+		i.current = index
+		if i.RoundRobin {
+			i.current = (index + 1) % len(i.writers)
+		}
+		return nil
+	}
+	return err
+}
+
+// withoutTag returns the points without the tag
+func withoutTag(points []*client.Point, tag string) ([]*client.Point, error) {
+	result := make([]*client.Point, 0, len(points))
 	for _, point := range points {
-		bp.AddPoint(point)
+		tags := point.Tags()
+		if _, ok := tags[tag]; !ok {
+			result = append(result, point)
+			continue
+		}
+		others := make(map[string]string, len(tags))
+		for k, v := range tags {
+			if k != tag {
+				others[k] = v
+			}
+		}
+		pt, err := client.NewPoint(point.Name(), others, point.Fields(), point.Time())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, pt)
 	}
-	log.Printf("[DEBUG] InfluxDB Write points")
-	// Write the batch
-	i.Client.Write(bp)
-	return nil
+	return result, nil
 }
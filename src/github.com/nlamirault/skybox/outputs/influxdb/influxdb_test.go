@@ -0,0 +1,548 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func fakeInfluxDBv2(t *testing.T, lines chan string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token xxxxxxxx" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(w, `{"code":"unauthorized","message":"unauthorized access"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/ping":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v2/write":
+			query := r.URL.Query()
+			if query.Get("org") != "home" || query.Get("bucket") != "skybox" ||
+				query.Get("precision") != "s" || r.Header.Get("Content-Encoding") != "gzip" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, `{"code":"invalid","message":"invalid request"}`)
+				return
+			}
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := ioutil.ReadAll(reader)
+			lines <- string(body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func newV2Configuration(url string, token string) *config.Configuration {
+	conf := config.New()
+	conf.InfluxDB.URL = url
+	conf.InfluxDB.Version = 2
+	conf.InfluxDB.Token = token
+	conf.InfluxDB.Organization = "home"
+	conf.InfluxDB.Bucket = "skybox"
+	conf.InfluxDB.Gzip = true
+	return conf
+}
+
+func TestInfluxDBv2Write(t *testing.T) {
+	lines := make(chan string, 1)
+	server := httptest.NewServer(fakeInfluxDBv2(t, lines))
+	defer server.Close()
+
+	output := New()
+	if err := output.Setup(newV2Configuration(server.URL+"/", "xxxxxxxx")); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "rate down=1000i,up=200i 1454284800\n" {
+		t.Fatalf("Invalid line protocol: %q", line)
+	}
+}
+
+func TestInfluxDBv2InvalidToken(t *testing.T) {
+	server := httptest.NewServer(fakeInfluxDBv2(t, nil))
+	defer server.Close()
+
+	output := New()
+	if err := output.Setup(newV2Configuration(server.URL, "invalid")); err != nil {
+		t.Fatal(err)
+	}
+	err := output.Connect()
+	if err == nil || !strings.Contains(err.Error(), "unauthorized access") {
+		t.Fatalf("Invalid error with an invalid token: %v", err)
+	}
+}
+
+func TestInfluxDBv2WithoutBucket(t *testing.T) {
+	conf := newV2Configuration("http://localhost:8086", "xxxxxxxx")
+	conf.InfluxDB.Bucket = ""
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error without bucket")
+	}
+}
+
+// fakeInfluxDBv1 counts the writes, and fails them if down
+func fakeInfluxDBv1(writes *int, down *bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, `{"results":[{}]}`)
+		case "/write":
+			if *down {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintln(w, `{"error":"timeout"}`)
+				return
+			}
+			*writes++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestInfluxDBFailover(t *testing.T) {
+	var writes1, writes2 int
+	var down1, down2 bool
+	server1 := httptest.NewServer(fakeInfluxDBv1(&writes1, &down1))
+	defer server1.Close()
+	server2 := httptest.NewServer(fakeInfluxDBv1(&writes2, &down2))
+	defer server2.Close()
+
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.URLs = []string{server1.URL, server2.URL}
+		conf.InfluxDB.Database = "skybox"
+	})
+	defer output.Close()
+
+	output.Write(outputstest.Rates(t, outputstest.Time, "freebox"))
+	down1 = true
+	output.Write(outputstest.Rates(t, outputstest.Time, "freebox"))
+	down1 = false
+	// The second server is used until it fails
+	output.Write(outputstest.Rates(t, outputstest.Time, "freebox"))
+	if writes1 != 1 || writes2 != 2 {
+		t.Fatalf("Invalid failover: %d / %d", writes1, writes2)
+	}
+	down1, down2 = true, true
+	var delays []time.Duration
+	output.sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err == nil {
+		t.Fatalf("No error with all servers down")
+	}
+	// Both servers are tried at each attempt
+	if len(delays) != 3 || delays[0] != time.Second || delays[2] != 4*time.Second {
+		t.Fatalf("Invalid retries: %v", delays)
+	}
+}
+
+// fakeInfluxDBErrors returns the responses in order to the writes, then
+// succeeds
+type fakeInfluxDBErrors struct {
+	Responses []func(w http.ResponseWriter)
+	Writes    int
+}
+
+func (f *fakeInfluxDBErrors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/query":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"results":[{}]}`)
+	case "/write":
+		f.Writes++
+		if f.Writes <= len(f.Responses) {
+			f.Responses[f.Writes-1](w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func errorResponse(status int, retryAfter string, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, body)
+	}
+}
+
+func newErrorsOutput(t *testing.T, urls ...string) (*InfluxDB, *[]time.Duration) {
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.URLs = urls
+		conf.InfluxDB.Database = "skybox"
+		conf.InfluxDB.MaxRetryDelay = 10
+	})
+	delays := &[]time.Duration{}
+	output.sleep = func(d time.Duration) {
+		*delays = append(*delays, d)
+	}
+	return output, delays
+}
+
+func TestInfluxDBWriteRetries(t *testing.T) {
+	fake := &fakeInfluxDBErrors{Responses: []func(w http.ResponseWriter){
+		errorResponse(http.StatusTooManyRequests, "60", `{"error":"too many requests"}`),
+		errorResponse(http.StatusServiceUnavailable, "", `{"error":"engine unavailable"}`),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output, delays := newErrorsOutput(t, server.URL)
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	// Retry-After is limited by the maximum delay
+	if fake.Writes != 3 || len(*delays) != 2 || (*delays)[0] != 10*time.Second || (*delays)[1] != 2*time.Second {
+		t.Fatalf("Invalid retries: %d %v", fake.Writes, *delays)
+	}
+}
+
+func TestInfluxDBMinimumRetryDelay(t *testing.T) {
+	output := New()
+	outputstest.Setup(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.RetryDelay = 0
+	})
+	if output.RetryDelay != time.Second {
+		t.Fatalf("Invalid retry delay: %s", output.RetryDelay)
+	}
+}
+
+func TestInfluxDBWriteInterrupt(t *testing.T) {
+	fake := &fakeInfluxDBErrors{}
+	for n := 0; n < 10; n++ {
+		fake.Responses = append(fake.Responses,
+			errorResponse(http.StatusServiceUnavailable, "60", `{"error":"engine unavailable"}`))
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output, _ := newErrorsOutput(t, server.URL)
+	output.sleep = func(d time.Duration) {
+		output.interruption.Wait(d)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		output.Interrupt()
+	}()
+	start := time.Now()
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err == nil {
+		t.Fatalf("No error with the server unavailable")
+	}
+	// The wait of 10s before the retry is aborted
+	if elapsed := time.Since(start); elapsed > 5*time.Second || fake.Writes != 1 {
+		t.Fatalf("Retries not interrupted: %s %d", elapsed, fake.Writes)
+	}
+	// The next writes aren't retried
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err == nil || fake.Writes != 2 {
+		t.Fatalf("Write retried after the interruption: %v %d", err, fake.Writes)
+	}
+}
+
+func TestInfluxDBWriteInvalidData(t *testing.T) {
+	fake1 := &fakeInfluxDBErrors{Responses: []func(w http.ResponseWriter){
+		errorResponse(http.StatusBadRequest, "", `{"error":"unable to parse 'rate down=': missing field value"}`),
+	}}
+	server1 := httptest.NewServer(fake1)
+	defer server1.Close()
+	fake2 := &fakeInfluxDBErrors{}
+	server2 := httptest.NewServer(fake2)
+	defer server2.Close()
+	output, delays := newErrorsOutput(t, server1.URL, server2.URL)
+	err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox"))
+	if err == nil || !strings.Contains(err.Error(), "missing field value") {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	// Invalid points are neither retried nor sent to the other servers
+	if fake1.Writes != 1 || fake2.Writes != 0 || len(*delays) != 0 {
+		t.Fatalf("Invalid writes: %d %d %v", fake1.Writes, fake2.Writes, *delays)
+	}
+}
+
+func TestInfluxDBPartialWrite(t *testing.T) {
+	fake := &fakeInfluxDBErrors{Responses: []func(w http.ResponseWriter){
+		errorResponse(http.StatusBadRequest, "",
+			`{"error":"partial write: field type conflict: input field \"down\" on measurement \"rate\" is type float, already exists as type integer dropped=1"}`),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output, _ := newErrorsOutput(t, server.URL)
+	err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox"))
+	e, ok := err.(*writeError)
+	if !ok || !e.Partial() || e.Dropped() != 1 || fake.Writes != 1 {
+		t.Fatalf("Invalid partial write: %v %d", err, fake.Writes)
+	}
+}
+
+func TestInfluxDBTransientErrors(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for err, expected := range map[error]bool{
+		&writeError{StatusCode: http.StatusTooManyRequests}:                                 true,
+		&writeError{StatusCode: http.StatusServiceUnavailable}:                              true,
+		&writeError{StatusCode: http.StatusBadRequest}:                                      false,
+		&writeError{StatusCode: http.StatusUnauthorized}:                                    false,
+		&url.Error{Op: "Post", URL: "http://localhost:8086", Err: refused}:                  true,
+		&url.Error{Op: "Post", URL: "http://localhost:8086", Err: io.EOF}:                   true,
+		&url.Error{Op: "parse", URL: "http://[::1", Err: errors.New("missing ']' in host")}: false,
+		refused: true,
+		errors.New("Time precision is not valid"): false,
+	} {
+		if outputs.Transient(err) != expected {
+			t.Fatalf("Invalid transient error %v: %v", err, !expected)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	_, err := http.Get(server.URL)
+	if err == nil || !outputs.Transient(err) {
+		t.Fatalf("Connection error is not transient: %v", err)
+	}
+}
+
+func TestInfluxDBRoundRobin(t *testing.T) {
+	var writes1, writes2 int
+	var down bool
+	server1 := httptest.NewServer(fakeInfluxDBv1(&writes1, &down))
+	defer server1.Close()
+	server2 := httptest.NewServer(fakeInfluxDBv1(&writes2, &down))
+	defer server2.Close()
+
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.URLs = []string{server1.URL, server2.URL}
+		conf.InfluxDB.RoundRobin = true
+	})
+	defer output.Close()
+	for n := 0; n < 4; n++ {
+		if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writes1 != 2 || writes2 != 2 {
+		t.Fatalf("Invalid round robin: %d / %d", writes1, writes2)
+	}
+}
+
+func TestInfluxDBUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.URLs = []string{"udp://" + server.LocalAddr().String()}
+		conf.InfluxDB.UDPPayload = 64
+	})
+	defer output.Close()
+	points := append(outputstest.Rates(t, outputstest.Time, "freebox"), outputstest.Rates(t, outputstest.Time, "freebox")...)
+	if err := output.Write(points); err != nil {
+		t.Fatal(err)
+	}
+	// Each point in its own packet, with nanoseconds timestamps as the
+	// UDP service has no precision
+	buf := make([]byte, 1024)
+	for n := 0; n < 2; n++ {
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		size, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Packet not received: %v", err)
+		}
+		if string(buf[:size]) != "rate down=1000i,up=200i 1454284800000000000\n" {
+			t.Fatalf("Invalid packet: %q", buf[:size])
+		}
+	}
+}
+
+func TestInfluxDBv2OverUDP(t *testing.T) {
+	conf := newV2Configuration("udp://localhost:8089", "xxxxxxxx")
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with UDP and InfluxDB 2.x")
+	}
+}
+
+// fakeInfluxDBRetention has the retention policy "autogen" and "skybox"
+// with a duration of 7 days
+func fakeInfluxDBRetention(queries *[]string, writes *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			query := r.URL.Query().Get("q")
+			*queries = append(*queries, query)
+			w.Header().Set("Content-Type", "application/json")
+			if strings.HasPrefix(query, "SHOW RETENTION POLICIES") {
+				fmt.Fprintln(w, `{"results":[{"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true],["skybox","168h0m0s","24h0m0s",1,false]]}]}]}`)
+				return
+			}
+			fmt.Fprintln(w, `{"results":[{}]}`)
+		case "/write":
+			*writes = append(*writes, r.URL.RawQuery)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestInfluxDBRetentionPolicy(t *testing.T) {
+	for _, test := range []struct {
+		Policy   string
+		Duration string
+		Query    string
+		Error    bool
+	}{
+		{"skybox", "7d", "", false},
+		{"skybox", "1w", "", false},
+		{"skybox", "30d", `ALTER RETENTION POLICY "skybox" ON "skybox" DURATION 30d`, false},
+		{"yearly", "52w", `CREATE RETENTION POLICY "yearly" ON "skybox" DURATION 52w REPLICATION 1`, false},
+		{"autogen", "", "", false},
+		{"yearly", "", "", true},
+	} {
+		var queries, writes []string
+		server := httptest.NewServer(fakeInfluxDBRetention(&queries, &writes))
+		output := New()
+		outputstest.Setup(t, output, func(conf *config.Configuration) {
+			conf.InfluxDB.URL = server.URL
+			conf.InfluxDB.Database = "skybox"
+			conf.InfluxDB.RetentionPolicy = test.Policy
+			conf.InfluxDB.RetentionDuration = test.Duration
+		})
+		err := output.Connect()
+		server.Close()
+		if test.Error {
+			if err == nil {
+				t.Fatalf("No error with %v", test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error with %v: %v", test, err)
+		}
+		last := queries[len(queries)-1]
+		if test.Query == "" && !strings.HasPrefix(last, "SHOW RETENTION POLICIES") ||
+			test.Query != "" && last != test.Query {
+			t.Fatalf("Invalid queries with %v: %v", test, queries)
+		}
+	}
+}
+
+func TestInfluxDBWriteParameters(t *testing.T) {
+	var queries, writes []string
+	server := httptest.NewServer(fakeInfluxDBRetention(&queries, &writes))
+	defer server.Close()
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.InfluxDB.URL = server.URL
+		conf.InfluxDB.Database = "skybox"
+		conf.InfluxDB.RetentionPolicy = "skybox"
+		conf.InfluxDB.Precision = "ms"
+		conf.InfluxDB.WriteConsistency = "quorum"
+	})
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 || writes[0] != "consistency=quorum&db=skybox&precision=ms&rp=skybox" {
+		t.Fatalf("Invalid write parameters: %v", writes)
+	}
+}
+
+func TestInfluxDBInvalidPrecision(t *testing.T) {
+	conf := config.New()
+	conf.InfluxDB.Precision = "us"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with microseconds and InfluxDB 1.x")
+	}
+	conf = newV2Configuration("http://localhost:8086", "xxxxxxxx")
+	conf.InfluxDB.Precision = "h"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with hours and InfluxDB 2.x")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"INF":      0,
+		"0s":       0,
+		"30d":      30 * 24 * time.Hour,
+		"2w":       14 * 24 * time.Hour,
+		"168h0m0s": 168 * time.Hour,
+		"90m":      90 * time.Minute,
+		"10u":      10 * time.Microsecond,
+	} {
+		if d, err := parseDuration(s); err != nil || d != expected {
+			t.Fatalf("Invalid duration %s: %v %v", s, d, err)
+		}
+	}
+	if _, err := parseDuration("30 days"); err == nil {
+		t.Fatalf("No error with an invalid duration")
+	}
+}
+
+func TestInfluxDBBoxTag(t *testing.T) {
+	lines := make(chan string, 1)
+	server := httptest.NewServer(fakeInfluxDBv2(t, lines))
+	defer server.Close()
+
+	conf := newV2Configuration(server.URL+"/", "xxxxxxxx")
+	conf.InfluxDB.BoxTag = true
+	output := New()
+	if err := output.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-lines; line != "rate,box=freebox down=1000i,up=200i 1454284800\n" {
+		t.Fatalf("Invalid line protocol: %q", line)
+	}
+}
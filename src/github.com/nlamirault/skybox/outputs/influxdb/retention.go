@@ -0,0 +1,134 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+var influxQLDuration = regexp.MustCompile(`^(\d+)(d|w)$`)
+
+// parseDuration parses an InfluxQL duration. INF, and zero, are infinite.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.ToUpper(s) == "INF" || s == "0" {
+		return 0, nil
+	}
+	if m := influxQLDuration.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit = 7 * 24 * time.Hour
+		}
+		return time.Duration(n) * unit, nil
+	}
+	if strings.HasSuffix(s, "u") || strings.HasSuffix(s, "µ") {
+		s += "s"
+	}
+	return time.ParseDuration(s)
+}
+
+func quoteIdentifier(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// retentionPolicyDuration returns the duration of the retention policy of the
+// database, and false if it doesn't exist
+func retentionPolicyDuration(c client.Client, database string, name string) (time.Duration, bool, error) {
+	resp, err := c.Query(client.Query{
+		Command:  fmt.Sprintf("SHOW RETENTION POLICIES ON %s", quoteIdentifier(database)),
+		Database: database,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if err := resp.Error(); err != nil {
+		return 0, false, err
+	}
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			nameColumn, durationColumn := -1, -1
+			for index, column := range row.Columns {
+				switch column {
+				case "name":
+					nameColumn = index
+				case "duration":
+					durationColumn = index
+				}
+			}
+			if nameColumn < 0 || durationColumn < 0 {
+				return 0, false, fmt.Errorf("InfluxDB invalid retention policies: %v", row.Columns)
+			}
+			for _, values := range row.Values {
+				if fmt.Sprintf("%v", values[nameColumn]) != name {
+					continue
+				}
+				duration, err := parseDuration(fmt.Sprintf("%v", values[durationColumn]))
+				if err != nil {
+					return 0, false, err
+				}
+				return duration, true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// setupRetentionPolicy creates the retention policy if it doesn't exist,
+// or updates its duration. Without duration, the retention policy must
+// exist.
+func setupRetentionPolicy(c client.Client, database string, name string, duration string) error {
+	current, exists, err := retentionPolicyDuration(c, database, name)
+	if err != nil {
+		return err
+	}
+	if duration == "" {
+		if !exists {
+			return fmt.Errorf("InfluxDB retention policy not found: %s", name)
+		}
+		return nil
+	}
+	expected, err := parseDuration(duration)
+	if err != nil {
+		return err
+	}
+	var command string
+	switch {
+	case !exists:
+		log.Printf("[INFO] InfluxDB create retention policy %s: %s", name, duration)
+		command = fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION 1",
+			quoteIdentifier(name), quoteIdentifier(database), duration)
+	case current != expected:
+		log.Printf("[INFO] InfluxDB update retention policy %s: %s", name, duration)
+		command = fmt.Sprintf("ALTER RETENTION POLICY %s ON %s DURATION %s",
+			quoteIdentifier(name), quoteIdentifier(database), duration)
+	default:
+		return nil
+	}
+	resp, err := c.Query(client.Query{Command: command, Database: database})
+	if err != nil {
+		return err
+	}
+	return resp.Error()
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// v1Client writes points using the InfluxDB 1.x HTTP or UDP API. The
+// client is used for the queries and the UDP writes. HTTP writes are sent
+// directly, to keep the status of the error responses.
+type v1Client struct {
+	URL        string
+	Client     client.Client
+	UDP        bool
+	Username   string
+	Password   string
+	UserAgent  string
+	HTTPClient *http.Client
+}
+
+// Ping checks the server is up. UDP servers can't be checked.
+func (c *v1Client) Ping() error {
+	if c.UDP {
+		return nil
+	}
+	resp, err := c.Client.Query(client.Query{
+		Command: fmt.Sprintf("SHOW DATABASES"),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] InfluxDB Check database response: %v", resp)
+	return nil
+}
+
+// Write sends the points in a batch. UDP batches are split into packets
+// of the payload size.
+func (c *v1Client) Write(points []*client.Point, conf client.BatchPointsConfig) error {
+	if c.UDP {
+		log.Printf("[DEBUG] InfluxDB Make points")
+		bp, err := client.NewBatchPoints(conf)
+		if err != nil {
+			return err
+		}
+		for _, point := range points {
+			bp.AddPoint(point)
+		}
+		log.Printf("[DEBUG] InfluxDB Write points to %s", c.URL)
+		return c.Client.Write(bp)
+	}
+
+	var buf bytes.Buffer
+	for _, point := range points {
+		buf.WriteString(point.PrecisionString(conf.Precision))
+		buf.WriteByte('\n')
+	}
+	params := url.Values{}
+	params.Set("db", conf.Database)
+	params.Set("rp", conf.RetentionPolicy)
+	params.Set("precision", conf.Precision)
+	params.Set("consistency", conf.WriteConsistency)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.URL, "/")+"/write?"+params.Encode(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	log.Printf("[DEBUG] InfluxDB Write %d points to %s", len(points), c.URL)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return newWriteError(resp, body)
+}
+
+func (c *v1Client) Close() error {
+	return c.Client.Close()
+}
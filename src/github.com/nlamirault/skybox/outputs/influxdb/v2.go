@@ -0,0 +1,136 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// v2Client writes line protocol using the InfluxDB 2.x API, with token
+// authentication. InfluxDB 3 supports this API too.
+type v2Client struct {
+	URL          string
+	Token        string
+	Organization string
+	Bucket       string
+	Gzip         bool
+	UserAgent    string
+	HTTPClient   *http.Client
+}
+
+func newV2Client(i *InfluxDB, u string) *v2Client {
+	return &v2Client{
+		URL:          strings.TrimSuffix(u, "/"),
+		Token:        i.Token,
+		Organization: i.Organization,
+		Bucket:       i.Bucket,
+		Gzip:         i.Gzip,
+		UserAgent:    i.UserAgent,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *v2Client) newRequest(method string, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+	return req, nil
+}
+
+func (c *v2Client) do(req *http.Request) error {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return newWriteError(resp, body)
+}
+
+// Ping checks the server is up
+func (c *v2Client) Ping() error {
+	req, err := c.newRequest("GET", "/ping", nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req)
+}
+
+func (c *v2Client) Close() error {
+	return nil
+}
+
+// Write sends the points in line protocol, optionally compressed. The
+// database of the batch configuration isn't used, points are written into
+// the bucket.
+func (c *v2Client) Write(points []*client.Point, conf client.BatchPointsConfig) error {
+	precision := conf.Precision
+	// The line protocol formatting uses u for microseconds
+	linePrecision := precision
+	if precision == "us" {
+		linePrecision = "u"
+	}
+	var buf bytes.Buffer
+	for _, point := range points {
+		buf.WriteString(point.PrecisionString(linePrecision))
+		buf.WriteByte('\n')
+	}
+	body := buf.Bytes()
+	if c.Gzip {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = gz.Bytes()
+	}
+	params := url.Values{}
+	params.Set("org", c.Organization)
+	params.Set("bucket", c.Bucket)
+	params.Set("precision", precision)
+	req, err := c.newRequest("POST", "/api/v2/write?"+params.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	log.Printf("[DEBUG] InfluxDB v2 Write %d points into %s", len(points), c.Bucket)
+	return c.do(req)
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"sync"
+	"time"
+)
+
+// Interrupter is implemented by the outputs which wait between the
+// retries of a write
+type Interrupter interface {
+	// Interrupt aborts the waits: the failed writes aren't retried anymore
+	Interrupt()
+}
+
+// Interrupt stops the retries of the output, so the agent doesn't wait
+// for them to stop
+func Interrupt(output Output) {
+	if i, ok := output.(Interrupter); ok {
+		i.Interrupt()
+	}
+}
+
+// Interruption aborts the waits between the retries of an output. A nil
+// Interruption is never interrupted.
+type Interruption struct {
+	once sync.Once
+	done chan struct{}
+}
+
+// NewInterruption returns an Interruption
+func NewInterruption() *Interruption {
+	return &Interruption{done: make(chan struct{})}
+}
+
+// Interrupt aborts the current and the next waits
+func (i *Interruption) Interrupt() {
+	if i == nil {
+		return
+	}
+	i.once.Do(func() {
+		close(i.done)
+	})
+}
+
+// Interrupted reports whether the retries are interrupted
+func (i *Interruption) Interrupted() bool {
+	if i == nil {
+		return false
+	}
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait waits for the delay, and returns false if interrupted
+func (i *Interruption) Wait(delay time.Duration) bool {
+	if i == nil {
+		time.Sleep(delay)
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-i.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
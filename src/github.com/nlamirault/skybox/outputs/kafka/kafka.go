@@ -0,0 +1,290 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+const defaultTimeout = 5 * time.Second
+
+func init() {
+	outputs.Add("kafka", func() outputs.Output {
+		return New()
+	})
+}
+
+// brokerConn is a connection to a broker
+type brokerConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Kafka produces each batch of metrics to a Kafka topic, with the box as
+// message key
+type Kafka struct {
+	Brokers      []string
+	Topic        string
+	ClientID     string
+	RequiredAcks int16
+	Format       string
+	Timeout      time.Duration
+
+	correlationID int32
+	// partitions are the partitions of the topic, sorted by identifier
+	partitions []partitionMetadata
+	// addresses are the addresses of the brokers, by identifier
+	addresses map[int32]string
+	conns     map[int32]*brokerConn
+}
+
+// New returns a Kafka Client
+func New() *Kafka {
+	return &Kafka{
+		Timeout: defaultTimeout,
+		conns:   map[int32]*brokerConn{},
+	}
+}
+
+func (k *Kafka) Setup(config *config.Configuration) error {
+	if config.Kafka == nil || len(config.Kafka.Brokers) == 0 {
+		return fmt.Errorf("Kafka configuration not found: %v", config)
+	}
+	if err := outputs.ValidFormat(config.Kafka.Format); err != nil {
+		return err
+	}
+	switch config.Kafka.RequiredAcks {
+	case -1, 0, 1:
+	default:
+		return fmt.Errorf("Kafka invalid required acks: %d", config.Kafka.RequiredAcks)
+	}
+	k.Brokers = config.Kafka.Brokers
+	k.Topic = config.Kafka.Topic
+	k.ClientID = config.Kafka.ClientID
+	k.RequiredAcks = int16(config.Kafka.RequiredAcks)
+	k.Format = config.Kafka.Format
+	log.Printf("[DEBUG] Kafka output: %v %s", k.Brokers, k.Topic)
+	return nil
+}
+
+// Connect retrieves the partitions of the topic from the first available
+// broker. The connections to the partition leaders are opened on write.
+func (k *Kafka) Connect() error {
+	log.Printf("[DEBUG] Kafka Connect: %v", k.Brokers)
+	var err error
+	for _, address := range k.Brokers {
+		if err = k.refreshMetadata(address); err == nil {
+			return nil
+		}
+		log.Printf("[WARN] Kafka broker %s unavailable: %s", address, err.Error())
+	}
+	return err
+}
+
+func (k *Kafka) refreshMetadata(address string) error {
+	conn, err := k.dial(address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	data, err := k.request(conn, apiKeyMetadata, metadataVersion, encodeMetadataRequest(k.Topic), true)
+	if err != nil {
+		return err
+	}
+	m, err := decodeMetadataResponse(data)
+	if err != nil {
+		return err
+	}
+	if m.ErrorCode != 0 {
+		return fmt.Errorf("Kafka metadata error %d for topic %s", m.ErrorCode, k.Topic)
+	}
+	if len(m.Partitions) == 0 {
+		return fmt.Errorf("Kafka topic without partitions: %s", k.Topic)
+	}
+	sort.Slice(m.Partitions, func(i, j int) bool {
+		return m.Partitions[i].ID < m.Partitions[j].ID
+	})
+	k.partitions = m.Partitions
+	k.addresses = map[int32]string{}
+	for _, b := range m.Brokers {
+		k.addresses[b.ID] = net.JoinHostPort(b.Host, strconv.Itoa(int(b.Port)))
+	}
+	log.Printf("[DEBUG] Kafka topic %s: %d partitions", k.Topic, len(k.partitions))
+	return nil
+}
+
+func (k *Kafka) Close() error {
+	var err error
+	for id, conn := range k.conns {
+		if e := conn.Close(); e != nil {
+			err = e
+		}
+		delete(k.conns, id)
+	}
+	return err
+}
+
+func (k *Kafka) Ping() error {
+	if k.partitions == nil {
+		return fmt.Errorf("Kafka Client not configured")
+	}
+	return k.Connect()
+}
+
+func (k *Kafka) Description() string {
+	return "Configuration for Kafka brokers to produce metrics to"
+}
+
+// Write produces a message by box. The partition of a message is the
+// hash of its key, the box.
+func (k *Kafka) Write(points []*client.Point) error {
+	if k.partitions == nil {
+		return fmt.Errorf("Kafka Client not configured")
+	}
+	batches := map[int][]message{}
+	boxes, groups := outputs.PointsByTag(points, "box")
+	for _, box := range boxes {
+		payload, err := outputs.EncodePoints(groups[box], k.Format)
+		if err != nil {
+			return err
+		}
+		key := []byte(box)
+		p := partition(key, len(k.partitions))
+		batches[p] = append(batches[p], message{Key: key, Value: payload})
+	}
+	log.Printf("[DEBUG] Kafka Write %d messages", len(boxes))
+	if err := k.produce(batches); err != nil {
+		// The connections or the leaders could have changed: retrieve the
+		// partitions and produce again once
+		log.Printf("[DEBUG] Kafka Write failed, reconnect: %s", err.Error())
+		k.Close()
+		if err := k.Connect(); err != nil {
+			return err
+		}
+		return k.produce(batches)
+	}
+	return nil
+}
+
+func (k *Kafka) produce(batches map[int][]message) error {
+	indexes := make([]int, 0, len(batches))
+	for index := range batches {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, index := range indexes {
+		p := k.partitions[index]
+		if p.ErrorCode != 0 || p.Leader < 0 {
+			return fmt.Errorf("Kafka partition %d unavailable: %d", p.ID, p.ErrorCode)
+		}
+		conn, err := k.leader(p.Leader)
+		if err != nil {
+			return err
+		}
+		body := encodeProduceRequest(k.Topic, k.RequiredAcks, int32(k.Timeout/time.Millisecond),
+			p.ID, encodeRecordBatch(batches[index], timestamp))
+		// Brokers don't respond without acknowledgement
+		data, err := k.request(conn, apiKeyProduce, produceVersion, body, k.RequiredAcks != 0)
+		if err != nil || k.RequiredAcks == 0 {
+			return err
+		}
+		code, err := decodeProduceResponse(data)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("Kafka produce error %d on partition %d", code, p.ID)
+		}
+	}
+	return nil
+}
+
+// leader returns the connection to a broker, opened if needed
+func (k *Kafka) leader(id int32) (*brokerConn, error) {
+	if conn, ok := k.conns[id]; ok {
+		return conn, nil
+	}
+	address, ok := k.addresses[id]
+	if !ok {
+		return nil, fmt.Errorf("Kafka unknown broker: %d", id)
+	}
+	conn, err := k.dial(address)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[id] = conn
+	return conn, nil
+}
+
+func (k *Kafka) dial(address string) (*brokerConn, error) {
+	conn, err := net.DialTimeout("tcp", address, k.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &brokerConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// request sends a request, and returns the response body without the
+// correlation identifier
+func (k *Kafka) request(conn *brokerConn, apiKey int16, version int16, body []byte, response bool) ([]byte, error) {
+	k.correlationID++
+	var e encoder
+	e.int32(0) // size, set below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(k.correlationID)
+	e.string(k.ClientID)
+	e = append(e, body...)
+	binary.BigEndian.PutUint32(e, uint32(len(e)-4))
+
+	if err := conn.SetDeadline(time.Now().Add(2 * k.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(e); err != nil {
+		return nil, err
+	}
+	if !response {
+		return nil, nil
+	}
+	var size int32
+	if err := binary.Read(conn.reader, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("Kafka invalid response size: %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn.reader, data); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(data)); id != k.correlationID {
+		return nil, fmt.Errorf("Kafka invalid correlation id: %d != %d", id, k.correlationID)
+	}
+	return data[4:], nil
+}
@@ -0,0 +1,246 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+type record struct {
+	Partition int32
+	Key       string
+	Value     string
+}
+
+// fakeBroker is the leader of the partitions of the topic. The first
+// produce request fails with NOT_LEADER_FOR_PARTITION if NotLeader is set.
+type fakeBroker struct {
+	Listener   net.Listener
+	Partitions int
+	NotLeader  bool
+	Metadata   int
+	Records    chan record
+}
+
+func newFakeBroker(t *testing.T, partitions int) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{
+		Listener:   listener,
+		Partitions: partitions,
+		Records:    make(chan record, 100),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(t, conn)
+		}
+	}()
+	return broker
+}
+
+func (f *fakeBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size int32
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		d := &decoder{data: data}
+		apiKey, _, correlationID, _ := d.int16(), d.int16(), d.int32(), d.string()
+		var resp encoder
+		resp.int32(correlationID)
+		switch apiKey {
+		case apiKeyMetadata:
+			f.Metadata++
+			host, port, _ := net.SplitHostPort(f.Listener.Addr().String())
+			p, _ := strconv.Atoi(port)
+			resp.int32(1)
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(p))
+			resp.nullString()
+			resp.int32(1) // controller
+			resp.int32(1)
+			resp.int16(0)
+			resp.string("skybox")
+			resp.int8(0)
+			resp.int32(int32(f.Partitions))
+			for i := 0; i < f.Partitions; i++ {
+				resp.int16(0)
+				resp.int32(int32(i))
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+				resp.int32(1)
+			}
+		case apiKeyProduce:
+			d.string() // transactional id
+			acks := d.int16()
+			d.int32() // timeout
+			d.int32() // topics
+			d.string()
+			d.int32() // partitions
+			partition := d.int32()
+			code := int16(0)
+			if f.NotLeader {
+				f.NotLeader = false
+				code = 6
+			} else {
+				f.decodeRecordBatch(t, partition, d.bytes())
+			}
+			if acks == 0 {
+				continue
+			}
+			resp.int32(1)
+			resp.string("skybox")
+			resp.int32(1)
+			resp.int32(partition)
+			resp.int16(code)
+			resp.int64(0)
+			resp.int64(-1)
+			resp.int32(0) // throttle
+		}
+		var out encoder
+		out.bytes(resp)
+		conn.Write(out)
+	}
+}
+
+func (f *fakeBroker) decodeRecordBatch(t *testing.T, partition int32, batch []byte) {
+	d := &decoder{data: batch}
+	d.int64() // base offset
+	body := &decoder{data: d.bytes()}
+	body.int32() // partition leader epoch
+	if magic := body.int8(); magic != 2 {
+		t.Errorf("Invalid magic: %d", magic)
+	}
+	crc := uint32(body.int32())
+	if crc != crc32.Checksum(body.data, castagnoli) {
+		t.Errorf("Invalid record batch CRC")
+	}
+	body.int16() // attributes
+	body.int32() // last offset delta
+	body.int64() // first timestamp
+	body.int64() // max timestamp
+	body.int64() // producer id
+	body.int16() // producer epoch
+	body.int32() // base sequence
+	for i := body.int32(); i > 0; i-- {
+		r := &decoder{data: body.varbytes()}
+		r.int8()
+		r.varint()
+		r.varint()
+		f.Records <- record{partition, string(r.varbytes()), string(r.varbytes())}
+	}
+	if body.err != nil {
+		t.Errorf("Invalid record batch: %s", body.err)
+	}
+}
+
+func receive(t *testing.T, records chan record) record {
+	select {
+	case r := <-records:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Record not received")
+	}
+	return record{}
+}
+
+func newKafka(t *testing.T, broker *fakeBroker) *Kafka {
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		// The first broker is unavailable
+		conf.Kafka.Brokers = []string{"127.0.0.1:1", broker.Listener.Addr().String()}
+	})
+	return output
+}
+
+func TestKafkaProduce(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+	defer broker.Listener.Close()
+	output := newKafka(t, broker)
+	defer output.Close()
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	r := receive(t, broker.Records)
+	var records []map[string]interface{}
+	if err := json.Unmarshal([]byte(r.Value), &records); err != nil {
+		t.Fatalf("Invalid value: %s", r.Value)
+	}
+	if r.Key != "freebox" || int(r.Partition) != partition([]byte("freebox"), 3) ||
+		len(records) != 1 || records[0]["measurement"] != "rate" {
+		t.Fatalf("Invalid record: %v", r)
+	}
+}
+
+func TestKafkaProduceRetry(t *testing.T) {
+	broker := newFakeBroker(t, 1)
+	defer broker.Listener.Close()
+	output := newKafka(t, broker)
+	defer output.Close()
+	broker.NotLeader = true
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox", "fritzbox")); err != nil {
+		t.Fatal(err)
+	}
+	// The metadata are retrieved again after the error
+	if broker.Metadata != 2 {
+		t.Fatalf("Invalid metadata requests: %d", broker.Metadata)
+	}
+	if r := receive(t, broker.Records); r.Key != "freebox" {
+		t.Fatalf("Invalid record: %v", r)
+	}
+	if r := receive(t, broker.Records); r.Key != "fritzbox" {
+		t.Fatalf("Invalid record: %v", r)
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// The values of the Java client tests
+	for key, hash := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if h := murmur2([]byte(key)); h != hash {
+			t.Errorf("Invalid murmur2 of %s: %d != %d", key, h, hash)
+		}
+	}
+}
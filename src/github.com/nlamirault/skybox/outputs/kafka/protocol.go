@@ -0,0 +1,336 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// The subset of the Kafka protocol used by the output: the Metadata (v1)
+// and Produce (v3) APIs, with record batches (magic 2) without
+// compression.
+
+const (
+	apiKeyProduce  = 0
+	apiKeyMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder encodes the Kafka primitive types
+type encoder []byte
+
+func (e *encoder) int8(v int8) {
+	*e = append(*e, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	*e = binary.BigEndian.AppendUint16(*e, uint16(v))
+}
+
+func (e *encoder) int32(v int32) {
+	*e = binary.BigEndian.AppendUint32(*e, uint32(v))
+}
+
+func (e *encoder) int64(v int64) {
+	*e = binary.BigEndian.AppendUint64(*e, uint64(v))
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(data []byte) {
+	e.int32(int32(len(data)))
+	*e = append(*e, data...)
+}
+
+// varint is a zigzag encoded variable length integer, used by the records
+func (e *encoder) varint(v int64) {
+	*e = binary.AppendVarint(*e, v)
+}
+
+func (e *encoder) varbytes(data []byte) {
+	e.varint(int64(len(data)))
+	*e = append(*e, data...)
+}
+
+// decoder decodes the Kafka primitive types. The first error is kept, and
+// the next reads return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = fmt.Errorf("Kafka truncated response")
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = fmt.Errorf("Kafka invalid varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// broker is a Kafka broker of the metadata
+type broker struct {
+	ID   int32
+	Host string
+	Port int32
+}
+
+// partitionMetadata is a partition of the topic, and its leader
+type partitionMetadata struct {
+	ErrorCode int16
+	ID        int32
+	Leader    int32
+}
+
+// metadata is the Metadata response for a topic
+type metadata struct {
+	Brokers    []broker
+	ErrorCode  int16
+	Partitions []partitionMetadata
+}
+
+func encodeMetadataRequest(topic string) []byte {
+	var e encoder
+	e.int32(1)
+	e.string(topic)
+	return e
+}
+
+func decodeMetadataResponse(data []byte) (*metadata, error) {
+	d := &decoder{data: data}
+	m := &metadata{}
+	for i := d.int32(); i > 0; i-- {
+		b := broker{ID: d.int32(), Host: d.string(), Port: d.int32()}
+		d.string() // rack
+		m.Brokers = append(m.Brokers, b)
+	}
+	d.int32() // controller id
+	if topics := d.int32(); topics != 1 && d.err == nil {
+		return nil, fmt.Errorf("Kafka invalid metadata: %d topics", topics)
+	}
+	m.ErrorCode = d.int16()
+	d.string() // name
+	d.int8()   // is internal
+	for i := d.int32(); i > 0; i-- {
+		p := partitionMetadata{ErrorCode: d.int16(), ID: d.int32(), Leader: d.int32()}
+		for j := d.int32(); j > 0; j-- {
+			d.int32() // replica
+		}
+		for j := d.int32(); j > 0; j-- {
+			d.int32() // in-sync replica
+		}
+		m.Partitions = append(m.Partitions, p)
+	}
+	return m, d.err
+}
+
+// message is a record of a batch
+type message struct {
+	Key   []byte
+	Value []byte
+}
+
+// encodeRecordBatch returns a record batch of the messages, created at
+// timestamp (in milliseconds)
+func encodeRecordBatch(messages []message, timestamp int64) []byte {
+	var records encoder
+	for i, msg := range messages {
+		var record encoder
+		record.int8(0)          // attributes
+		record.varint(0)        // timestamp delta
+		record.varint(int64(i)) // offset delta
+		record.varbytes(msg.Key)
+		record.varbytes(msg.Value)
+		record.varint(0) // headers
+		records.varbytes(record)
+	}
+
+	// The CRC covers the batch from the attributes
+	var tail encoder
+	tail.int16(0) // attributes
+	tail.int32(int32(len(messages) - 1))
+	tail.int64(timestamp)
+	tail.int64(timestamp)
+	tail.int64(-1) // producer id
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(messages)))
+	tail = append(tail, records...)
+
+	var body encoder
+	body.int32(-1) // partition leader epoch
+	body.int8(2)   // magic
+	body.int32(int32(crc32.Checksum(tail, castagnoli)))
+	body = append(body, tail...)
+
+	var batch encoder
+	batch.int64(0) // base offset
+	batch.bytes(body)
+	return batch
+}
+
+func encodeProduceRequest(topic string, acks int16, timeout int32, partition int32, batch []byte) []byte {
+	var e encoder
+	e.nullString() // transactional id
+	e.int16(acks)
+	e.int32(timeout)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(batch)
+	return e
+}
+
+// decodeProduceResponse returns the error code of the partition
+func decodeProduceResponse(data []byte) (int16, error) {
+	d := &decoder{data: data}
+	var errorCode int16
+	for i := d.int32(); i > 0; i-- {
+		d.string() // topic
+		for j := d.int32(); j > 0; j-- {
+			d.int32() // partition
+			if code := d.int16(); code != 0 {
+				errorCode = code
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	return errorCode, d.err
+}
+
+// murmur2 is the hash of the Kafka default partitioner, so the messages
+// of a box go to the same partition than with the Java client
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// partition returns the partition of a key
+func partition(key []byte, partitions int) int {
+	return int(murmur2(key)&0x7fffffff) % partitions
+}
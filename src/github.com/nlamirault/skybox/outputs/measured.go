@@ -0,0 +1,81 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/selfstat"
+)
+
+// Measured is an Output which records the latency, the points and the
+// failures of the writes in the internal metrics
+type Measured struct {
+	Output Output
+	// Name is the output plugin name, used as the output tag
+	Name string
+}
+
+// NewMeasured returns a Measured of the output
+func NewMeasured(output Output, name string) *Measured {
+	return &Measured{
+		Output: output,
+		Name:   name,
+	}
+}
+
+func (m *Measured) Setup(config *config.Configuration) error {
+	return m.Output.Setup(config)
+}
+
+func (m *Measured) Connect() error {
+	return m.Output.Connect()
+}
+
+func (m *Measured) Close() error {
+	return m.Output.Close()
+}
+
+func (m *Measured) Ping() error {
+	return m.Output.Ping()
+}
+
+// Interrupt interrupts the retries of the output
+func (m *Measured) Interrupt() {
+	Interrupt(m.Output)
+}
+
+func (m *Measured) Description() string {
+	return m.Output.Description()
+}
+
+// Write writes the points, and records the duration in milliseconds
+func (m *Measured) Write(points []*client.Point) error {
+	start := time.Now()
+	err := m.Output.Write(points)
+	tags := map[string]string{"output": m.Name}
+	selfstat.Set("skybox_write", tags, "duration", time.Since(start).Seconds()*1000)
+	selfstat.Add("skybox_write", tags, "writes", 1)
+	if err != nil {
+		selfstat.Add("skybox_write", tags, "failures", 1)
+		return err
+	}
+	selfstat.Add("skybox_write", tags, "failures", 0)
+	selfstat.Add("skybox_write", tags, "points", int64(len(points)))
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outputs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/selfstat"
+)
+
+func TestMeasuredWrite(t *testing.T) {
+	output := &fakeOutput{}
+	measured := NewMeasured(output, "fake")
+	if err := measured.Write(newBatchPoints(t, 3)); err != nil {
+		t.Fatal(err)
+	}
+	output.Down = true
+	if err := measured.Write(newBatchPoints(t, 2)); err == nil {
+		t.Fatalf("No error with output down")
+	}
+
+	points, err := selfstat.Points(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pt := range points {
+		if pt.Name() != "skybox_write" || pt.Tags()["output"] != "fake" {
+			continue
+		}
+		fields := pt.Fields()
+		if fields["writes"] != int64(2) || fields["failures"] != int64(1) || fields["points"] != int64(3) {
+			t.Fatalf("Invalid write metrics: %v", fields)
+		}
+		if _, ok := fields["duration"].(float64); !ok {
+			t.Fatalf("No write duration: %v", fields)
+		}
+		return
+	}
+	t.Fatalf("No write metrics: %v", points)
+}
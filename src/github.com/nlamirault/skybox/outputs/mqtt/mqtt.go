@@ -0,0 +1,310 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var (
+	templateTag = regexp.MustCompile(`\{([^}]+)\}`)
+
+	invalidTopicChars = regexp.MustCompile(`[/+#\s]+`)
+
+	invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+)
+
+func init() {
+	outputs.Add("mqtt", func() outputs.Output {
+		return New()
+	})
+}
+
+// sensor describes a metric for Home Assistant. The empty attributes
+// aren't published: the text sensors have no unit nor classes.
+type sensor struct {
+	Unit        string
+	DeviceClass string
+	StateClass  string
+	Icon        string
+}
+
+// sensors are the Home Assistant sensors of the measurements, or of a
+// measurement field
+var sensors = map[string]sensor{
+	"rate":              {"B/s", "data_rate", "measurement", ""},
+	"bytes":             {"B", "data_size", "total_increasing", ""},
+	"bandwidth":         {"bit/s", "data_rate", "measurement", ""},
+	"connection.state":  {"", "", "", "mdi:wan"},
+	"connection.uptime": {"s", "duration", "measurement", ""},
+	"connection.ipv4":   {"", "", "", "mdi:ip-network"},
+}
+
+// MQTT publishes metrics to a MQTT broker
+type MQTT struct {
+	Address         string
+	ClientID        string
+	Username        string
+	Password        string
+	Topic           string
+	QoS             byte
+	Retain          bool
+	Discovery       bool
+	DiscoveryPrefix string
+	Timeout         time.Duration
+	Conn            net.Conn
+
+	reader *bufio.Reader
+	// packetID is the identifier of the last QoS 1 publish
+	packetID uint16
+	// discovered are the topics announced to Home Assistant
+	discovered map[string]bool
+}
+
+// New returns a MQTT Client
+func New() *MQTT {
+	return &MQTT{
+		Timeout:    defaultTimeout,
+		discovered: map[string]bool{},
+	}
+}
+
+func (m *MQTT) Setup(config *config.Configuration) error {
+	if config.MQTT == nil {
+		return fmt.Errorf("MQTT configuration not found: %v", config)
+	}
+	if config.MQTT.QoS < 0 || config.MQTT.QoS > 1 {
+		return fmt.Errorf("MQTT unsupported QoS: %d", config.MQTT.QoS)
+	}
+	// MQTT 3.1.1 doesn't allow a password without user name
+	if config.MQTT.Password != "" && config.MQTT.Username == "" {
+		return fmt.Errorf("MQTT password without username")
+	}
+	m.Address = config.MQTT.Address
+	m.ClientID = config.MQTT.ClientID
+	m.Username = config.MQTT.Username
+	m.Password = config.MQTT.Password
+	m.Topic = config.MQTT.Topic
+	m.QoS = byte(config.MQTT.QoS)
+	m.Retain = config.MQTT.Retain
+	m.Discovery = config.MQTT.Discovery
+	m.DiscoveryPrefix = config.MQTT.DiscoveryPrefix
+	log.Printf("[DEBUG] MQTT output: %s %s", m.Address, m.Topic)
+	return nil
+}
+
+func (m *MQTT) Connect() error {
+	log.Printf("[DEBUG] MQTT Connect: %s", m.Address)
+	conn, err := net.DialTimeout("tcp", m.Address, m.Timeout)
+	if err != nil {
+		return err
+	}
+	m.Conn = conn
+	m.reader = bufio.NewReader(conn)
+	resp, err := m.request(newConnectPacket(m.ClientID, m.Username, m.Password), connackPacket)
+	if err != nil {
+		m.Close()
+		return err
+	}
+	if len(resp.Body) != 2 {
+		m.Close()
+		return fmt.Errorf("MQTT invalid CONNACK")
+	}
+	if code := resp.Body[1]; code != 0 {
+		m.Close()
+		if msg, ok := connackErrors[code]; ok {
+			return fmt.Errorf("MQTT connection refused: %s", msg)
+		}
+		return fmt.Errorf("MQTT connection refused: %d", code)
+	}
+	return nil
+}
+
+func (m *MQTT) Close() error {
+	if m.Conn == nil {
+		return nil
+	}
+	m.send(&packet{Type: disconnectPacket})
+	err := m.Conn.Close()
+	m.Conn = nil
+	return err
+}
+
+func (m *MQTT) Ping() error {
+	if m.Conn == nil {
+		return fmt.Errorf("MQTT Client not configured")
+	}
+	_, err := m.request(&packet{Type: pingreqPacket}, pingrespPacket)
+	return err
+}
+
+func (m *MQTT) Description() string {
+	return "Configuration for MQTT broker to publish metrics to"
+}
+
+func (m *MQTT) Write(points []*client.Point) error {
+	if err := m.publishPoints(points); err != nil {
+		// The connection could be closed by the broker: connect again once
+		log.Printf("[DEBUG] MQTT Write failed, reconnect: %s", err.Error())
+		m.Close()
+		if err := m.Connect(); err != nil {
+			return err
+		}
+		return m.publishPoints(points)
+	}
+	return nil
+}
+
+func (m *MQTT) publishPoints(points []*client.Point) error {
+	if m.Conn == nil {
+		return fmt.Errorf("MQTT Client not configured")
+	}
+	for _, point := range points {
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			topic := m.topic(point, name)
+			if m.Discovery && !m.discovered[topic] {
+				if err := m.discover(point, name, topic); err != nil {
+					return err
+				}
+				m.discovered[topic] = true
+			}
+			payload := []byte(fmt.Sprintf("%v", fields[name]))
+			if err := m.publish(topic, payload, m.Retain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// discover publishes the Home Assistant discovery config of a metric
+func (m *MQTT) discover(point *client.Point, field string, topic string) error {
+	box := point.Tags()["box"]
+	if box == "" {
+		box = "skybox"
+	}
+	node := "skybox_" + invalidIDChars.ReplaceAllString(box, "_")
+	object := invalidIDChars.ReplaceAllString(point.Name()+"_"+field, "_")
+	discovery := map[string]interface{}{
+		"name":        fmt.Sprintf("%s %s", point.Name(), field),
+		"unique_id":   node + "_" + object,
+		"state_topic": topic,
+		"device": map[string]interface{}{
+			"identifiers": []string{node},
+			"name":        box,
+			"model":       "skybox",
+		},
+	}
+	s, ok := sensors[point.Name()+"."+field]
+	if !ok {
+		s, ok = sensors[point.Name()]
+	}
+	for name, value := range map[string]string{
+		"unit_of_measurement": s.Unit,
+		"device_class":        s.DeviceClass,
+		"state_class":         s.StateClass,
+		"icon":                s.Icon,
+	} {
+		if ok && value != "" {
+			discovery[name] = value
+		}
+	}
+	payload, err := json.Marshal(discovery)
+	if err != nil {
+		return err
+	}
+	configTopic := fmt.Sprintf("%s/sensor/%s/%s/config", m.DiscoveryPrefix, node, object)
+	log.Printf("[DEBUG] MQTT Home Assistant discovery: %s", configTopic)
+	return m.publish(configTopic, payload, true)
+}
+
+func (m *MQTT) publish(topic string, payload []byte, retain bool) error {
+	if m.QoS == 0 {
+		return m.send(newPublishPacket(topic, payload, 0, retain, 0))
+	}
+	m.packetID++
+	if m.packetID == 0 {
+		m.packetID = 1
+	}
+	resp, err := m.request(newPublishPacket(topic, payload, m.QoS, retain, m.packetID), pubackPacket)
+	if err != nil {
+		return err
+	}
+	if len(resp.Body) != 2 || uint16(resp.Body[0])<<8|uint16(resp.Body[1]) != m.packetID {
+		return fmt.Errorf("MQTT invalid PUBACK for %s", topic)
+	}
+	return nil
+}
+
+func (m *MQTT) send(p *packet) error {
+	if err := m.Conn.SetWriteDeadline(time.Now().Add(m.Timeout)); err != nil {
+		return err
+	}
+	_, err := p.WriteTo(m.Conn)
+	return err
+}
+
+// request sends a packet and waits for the response packet
+func (m *MQTT) request(p *packet, responseType byte) (*packet, error) {
+	if err := m.send(p); err != nil {
+		return nil, err
+	}
+	if err := m.Conn.SetReadDeadline(time.Now().Add(m.Timeout)); err != nil {
+		return nil, err
+	}
+	resp, err := readPacket(m.reader)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type != responseType {
+		return nil, fmt.Errorf("MQTT unexpected packet type: %d", resp.Type)
+	}
+	return resp, nil
+}
+
+// topic returns the topic of a field, using the template. {measurement}
+// and {field} are replaced by the point name and the field name, the
+// others by the point tags.
+func (m *MQTT) topic(point *client.Point, field string) string {
+	tags := point.Tags()
+	return templateTag.ReplaceAllStringFunc(m.Topic, func(s string) string {
+		name := s[1 : len(s)-1]
+		var value string
+		switch name {
+		case "measurement":
+			value = point.Name()
+		case "field":
+			value = field
+		default:
+			value = tags[name]
+		}
+		return invalidTopicChars.ReplaceAllString(strings.TrimSpace(value), "_")
+	})
+}
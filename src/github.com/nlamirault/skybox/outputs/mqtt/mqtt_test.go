@@ -0,0 +1,226 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+type message struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// fakeBroker accepts one client, refuses the connection if the password
+// is invalid, and acknowledges the QoS 1 publish
+func fakeBroker(t *testing.T, password string) (net.Listener, chan message) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan message, 100)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		connect, err := readPacket(reader)
+		if err != nil || connect.Type != connectPacket {
+			return
+		}
+		code := byte(0)
+		if !strings.HasSuffix(string(connect.Body), password) {
+			code = 4
+		}
+		(&packet{Type: connackPacket, Body: []byte{0, code}}).WriteTo(conn)
+		for {
+			p, err := readPacket(reader)
+			if err != nil {
+				return
+			}
+			switch p.Type {
+			case publishPacket:
+				length := int(binary.BigEndian.Uint16(p.Body))
+				topic := string(p.Body[2 : 2+length])
+				payload := p.Body[2+length:]
+				if p.Flags&0x06 != 0 {
+					(&packet{Type: pubackPacket, Body: payload[:2]}).WriteTo(conn)
+					payload = payload[2:]
+				}
+				messages <- message{topic, string(payload), p.Flags&0x01 != 0}
+			case pingreqPacket:
+				(&packet{Type: pingrespPacket}).WriteTo(conn)
+			case disconnectPacket:
+				return
+			}
+		}
+	}()
+	return listener, messages
+}
+
+func newPoints(t *testing.T) []*client.Point {
+	return append(outputstest.Rates(t, time.Now(), "freebox"),
+		outputstest.Point(t, "connection", map[string]string{"box": "freebox"},
+			map[string]interface{}{"state": "up"}, time.Now()))
+}
+
+func receive(t *testing.T, messages chan message) message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Message not received")
+	}
+	return message{}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	listener, messages := fakeBroker(t, "secret")
+	defer listener.Close()
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.MQTT.Address = listener.Addr().String()
+		conf.MQTT.Username = "skybox"
+		conf.MQTT.Password = "secret"
+		conf.MQTT.QoS = 1
+	})
+	defer output.Close()
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []message{
+		{"skybox/freebox/rate/down", "1000", false},
+		{"skybox/freebox/rate/up", "200", false},
+		{"skybox/freebox/connection/state", "up", false},
+	} {
+		if msg := receive(t, messages); msg != expected {
+			t.Fatalf("Invalid message: %v", msg)
+		}
+	}
+}
+
+func TestMQTTHomeAssistantDiscovery(t *testing.T) {
+	listener, messages := fakeBroker(t, "")
+	defer listener.Close()
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.MQTT.Address = listener.Addr().String()
+		conf.MQTT.Discovery = true
+	})
+	defer output.Close()
+	points := newPoints(t)
+	if err := output.Write(points[:1]); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, messages)
+	if msg.Topic != "homeassistant/sensor/skybox_freebox/rate_down/config" || !msg.Retain {
+		t.Fatalf("Invalid discovery message: %v", msg)
+	}
+	var discovery map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.Payload), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["state_topic"] != "skybox/freebox/rate/down" ||
+		discovery["unit_of_measurement"] != "B/s" ||
+		discovery["unique_id"] != "skybox_freebox_rate_down" {
+		t.Fatalf("Invalid discovery config: %v", discovery)
+	}
+	if msg := receive(t, messages); msg.Topic != "skybox/freebox/rate/down" {
+		t.Fatalf("Invalid message: %v", msg)
+	}
+	// Configs are published once
+	if err := output.Write(points[:1]); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, messages)
+	receive(t, messages)
+	if msg := receive(t, messages); msg.Topic != "skybox/freebox/rate/down" {
+		t.Fatalf("Discovery config published again: %v", msg)
+	}
+}
+
+func TestMQTTConnectionRefused(t *testing.T) {
+	listener, _ := fakeBroker(t, "secret")
+	defer listener.Close()
+	output := New()
+	outputstest.Setup(t, output, func(conf *config.Configuration) {
+		conf.MQTT.Address = listener.Addr().String()
+		conf.MQTT.Username = "skybox"
+		conf.MQTT.Password = "invalid"
+	})
+	err := output.Connect()
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Fatalf("Invalid connection error: %v", err)
+	}
+}
+
+func TestMQTTConnectionStateDiscovery(t *testing.T) {
+	listener, messages := fakeBroker(t, "")
+	defer listener.Close()
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.MQTT.Address = listener.Addr().String()
+		conf.MQTT.Discovery = true
+	})
+	defer output.Close()
+	if err := output.Write(newPoints(t)[1:]); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, messages)
+	if msg.Topic != "homeassistant/sensor/skybox_freebox/connection_state/config" {
+		t.Fatalf("Invalid discovery message: %v", msg)
+	}
+	var discovery map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.Payload), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	// The state is a text sensor
+	if discovery["icon"] != "mdi:wan" || discovery["unit_of_measurement"] != nil ||
+		discovery["state_class"] != nil {
+		t.Fatalf("Invalid discovery config: %v", discovery)
+	}
+	if msg := receive(t, messages); msg.Topic != "skybox/freebox/connection/state" || msg.Payload != "up" {
+		t.Fatalf("Invalid message: %v", msg)
+	}
+}
+
+func TestMQTTPasswordWithoutUsername(t *testing.T) {
+	conf := config.New()
+	conf.MQTT.Password = "secret"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with a password without username")
+	}
+	// The password flag is only set with a user name
+	if flags := newConnectPacket("skybox", "", "secret").Body[7]; flags&0x40 != 0 {
+		t.Fatalf("Password flag without username: %x", flags)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	connectPacket    = 1
+	connackPacket    = 2
+	publishPacket    = 3
+	pubackPacket     = 4
+	pingreqPacket    = 12
+	pingrespPacket   = 13
+	disconnectPacket = 14
+)
+
+// connackErrors are the messages of the CONNACK return codes
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// packet is a MQTT control packet
+type packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+func (p *packet) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(p.Type<<4 | p.Flags)
+	// Remaining length is encoded 7 bits per byte
+	length := len(p.Body)
+	for {
+		b := byte(length % 128)
+		length = length / 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+	buf.Write(p.Body)
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := 0
+	for multiplier := 1; ; multiplier *= 128 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if multiplier > 128*128 {
+			return nil, fmt.Errorf("MQTT invalid remaining length")
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{Type: header >> 4, Flags: header & 0x0f, Body: body}, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func newConnectPacket(clientID string, username string, password string) *packet {
+	var buf bytes.Buffer
+	writeString(&buf, "MQTT")
+	// Protocol level 4 is MQTT 3.1.1
+	buf.WriteByte(4)
+	// Clean session
+	flags := byte(0x02)
+	if username != "" {
+		flags |= 0x80
+	}
+	// The password requires a user name
+	if username != "" && password != "" {
+		flags |= 0x40
+	}
+	buf.WriteByte(flags)
+	// Keep alive disabled: the connection is only used to publish, and
+	// a broken connection is detected on the next publish
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	writeString(&buf, clientID)
+	if username != "" {
+		writeString(&buf, username)
+	}
+	if username != "" && password != "" {
+		writeString(&buf, password)
+	}
+	return &packet{Type: connectPacket, Body: buf.Bytes()}
+}
+
+func newPublishPacket(topic string, payload []byte, qos byte, retain bool, id uint16) *packet {
+	var buf bytes.Buffer
+	writeString(&buf, topic)
+	if qos > 0 {
+		binary.Write(&buf, binary.BigEndian, id)
+	}
+	buf.Write(payload)
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+	return &packet{Type: publishPacket, Flags: flags, Body: buf.Bytes()}
+}
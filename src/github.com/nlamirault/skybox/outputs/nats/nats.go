@@ -0,0 +1,275 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/version"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var (
+	templateTag = regexp.MustCompile(`\{([^}]+)\}`)
+
+	invalidSubjectChars = regexp.MustCompile(`[.*>\s]+`)
+)
+
+func init() {
+	outputs.Add("nats", func() outputs.Output {
+		return New()
+	})
+}
+
+// serverInfo is the INFO message sent by the server
+type serverInfo struct {
+	ServerID     string `json:"server_id"`
+	Version      string `json:"version"`
+	MaxPayload   int    `json:"max_payload"`
+	AuthRequired bool   `json:"auth_required"`
+	TLSRequired  bool   `json:"tls_required"`
+}
+
+// connectOptions is the CONNECT message sent by the client
+type connectOptions struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	Protocol  int    `json:"protocol"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// NATS publishes each batch of metrics to a NATS subject by box
+type NATS struct {
+	Address  string
+	Username string
+	Password string
+	Token    string
+	Subject  string
+	Format   string
+	Timeout  time.Duration
+	Conn     net.Conn
+
+	reader *bufio.Reader
+	info   serverInfo
+}
+
+// New returns a NATS Client
+func New() *NATS {
+	return &NATS{
+		Timeout: defaultTimeout,
+	}
+}
+
+func (n *NATS) Setup(config *config.Configuration) error {
+	if config.NATS == nil {
+		return fmt.Errorf("NATS configuration not found: %v", config)
+	}
+	if err := outputs.ValidFormat(config.NATS.Format); err != nil {
+		return err
+	}
+	n.Address = config.NATS.Address
+	n.Username = config.NATS.Username
+	n.Password = config.NATS.Password
+	n.Token = config.NATS.Token
+	n.Subject = config.NATS.Subject
+	n.Format = config.NATS.Format
+	log.Printf("[DEBUG] NATS output: %s %s", n.Address, n.Subject)
+	return nil
+}
+
+// Connect performs the handshake with the server, and waits for the
+// server to accept the connection
+func (n *NATS) Connect() error {
+	log.Printf("[DEBUG] NATS Connect: %s", n.Address)
+	conn, err := net.DialTimeout("tcp", n.Address, n.Timeout)
+	if err != nil {
+		return err
+	}
+	n.Conn = conn
+	n.reader = bufio.NewReader(conn)
+	if err := n.handshake(); err != nil {
+		n.Close()
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) handshake() error {
+	if err := n.Conn.SetDeadline(time.Now().Add(n.Timeout)); err != nil {
+		return err
+	}
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("NATS invalid server greeting: %s", line)
+	}
+	if err := json.Unmarshal([]byte(line[5:]), &n.info); err != nil {
+		return err
+	}
+	if n.info.TLSRequired {
+		return fmt.Errorf("NATS server requires TLS, which isn't supported")
+	}
+	log.Printf("[DEBUG] NATS server: %s %s", n.info.ServerID, n.info.Version)
+	options, err := json.Marshal(connectOptions{
+		Name:      "skybox",
+		Lang:      "go",
+		Version:   version.Version,
+		Protocol:  1,
+		User:      n.Username,
+		Pass:      n.Password,
+		AuthToken: n.Token,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(n.Conn, "CONNECT %s\r\n", options); err != nil {
+		return err
+	}
+	return n.flush()
+}
+
+func (n *NATS) Close() error {
+	if n.Conn == nil {
+		return nil
+	}
+	err := n.Conn.Close()
+	n.Conn = nil
+	return err
+}
+
+func (n *NATS) Ping() error {
+	if n.Conn == nil {
+		return fmt.Errorf("NATS Client not configured")
+	}
+	if err := n.Conn.SetDeadline(time.Now().Add(n.Timeout)); err != nil {
+		return err
+	}
+	return n.flush()
+}
+
+func (n *NATS) Description() string {
+	return "Configuration for NATS server to publish metrics to"
+}
+
+// Write publishes a message by box, and waits for the server to process
+// them
+func (n *NATS) Write(points []*client.Point) error {
+	var buf bytes.Buffer
+	boxes, groups := outputs.PointsByTag(points, "box")
+	for _, box := range boxes {
+		payload, err := outputs.EncodePoints(groups[box], n.Format)
+		if err != nil {
+			return err
+		}
+		if n.info.MaxPayload > 0 && len(payload) > n.info.MaxPayload {
+			return fmt.Errorf("NATS payload too large: %d > %d", len(payload), n.info.MaxPayload)
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", n.subject(box), len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	log.Printf("[DEBUG] NATS Write %d messages", len(boxes))
+	if err := n.publish(buf.Bytes()); err != nil {
+		// The connection could be closed by the server: connect again once
+		log.Printf("[DEBUG] NATS Write failed, reconnect: %s", err.Error())
+		n.Close()
+		if err := n.Connect(); err != nil {
+			return err
+		}
+		return n.publish(buf.Bytes())
+	}
+	return nil
+}
+
+func (n *NATS) publish(data []byte) error {
+	if n.Conn == nil {
+		return fmt.Errorf("NATS Client not configured")
+	}
+	if err := n.Conn.SetDeadline(time.Now().Add(n.Timeout)); err != nil {
+		return err
+	}
+	if _, err := n.Conn.Write(data); err != nil {
+		return err
+	}
+	return n.flush()
+}
+
+// flush sends a PING, and reads the server messages until the PONG.
+// Errors sent by the server are returned.
+func (n *NATS) flush() error {
+	if _, err := n.Conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.Conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.Trim(strings.TrimSpace(line[4:]), "'"))
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		default:
+			return fmt.Errorf("NATS unexpected message: %s", line)
+		}
+	}
+}
+
+func (n *NATS) readLine() (string, error) {
+	line, err := n.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// subject returns the subject of a box, using the template
+func (n *NATS) subject(box string) string {
+	return templateTag.ReplaceAllStringFunc(n.Subject, func(s string) string {
+		if s != "{box}" || box == "" {
+			return "_"
+		}
+		return invalidSubjectChars.ReplaceAllString(box, "_")
+	})
+}
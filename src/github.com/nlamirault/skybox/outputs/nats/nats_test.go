@@ -0,0 +1,155 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+type message struct {
+	Subject string
+	Payload string
+}
+
+// fakeServer accepts one client, refuses the connection if the token is
+// invalid, and sends a PING before answering the client PINGs
+func fakeServer(t *testing.T, token string) (net.Listener, chan message) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan message, 100)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, `INFO {"server_id":"fake","version":"2.10.0","max_payload":1048576,"auth_required":true}`+"\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				var options connectOptions
+				json.Unmarshal([]byte(line[8:]), &options)
+				if options.AuthToken != token {
+					fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+					return
+				}
+			case strings.HasPrefix(line, "PUB "):
+				var subject string
+				var size int
+				fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				messages <- message{subject, string(payload[:size])}
+			case line == "PING":
+				fmt.Fprint(conn, "PING\r\nPONG\r\n")
+			}
+		}
+	}()
+	return listener, messages
+}
+
+func newNATS(t *testing.T, address string, format string) *NATS {
+	output := New()
+	outputstest.Setup(t, output, func(conf *config.Configuration) {
+		conf.NATS.Address = address
+		conf.NATS.Token = "secret"
+		conf.NATS.Format = format
+	})
+	return output
+}
+
+func receive(t *testing.T, messages chan message) message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Message not received")
+	}
+	return message{}
+}
+
+func TestNATSPublish(t *testing.T) {
+	listener, messages := fakeServer(t, "secret")
+	defer listener.Close()
+	output := newNATS(t, listener.Addr().String(), "json")
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox", "fritz.box")); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, messages)
+	var records []map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.Payload), &records); err != nil {
+		t.Fatalf("Invalid payload: %s", msg.Payload)
+	}
+	if msg.Subject != "skybox.freebox" || len(records) != 1 || records[0]["measurement"] != "rate" {
+		t.Fatalf("Invalid message: %v", msg)
+	}
+	// Dots are subject token separators
+	if msg := receive(t, messages); msg.Subject != "skybox.fritz_box" {
+		t.Fatalf("Invalid subject: %s", msg.Subject)
+	}
+}
+
+func TestNATSLineProtocol(t *testing.T) {
+	listener, messages := fakeServer(t, "secret")
+	defer listener.Close()
+	output := newNATS(t, listener.Addr().String(), "line")
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox", "fritz.box")[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, messages); msg.Payload != "rate,box=freebox down=1000i,up=200i 1454284800000000000\n" {
+		t.Fatalf("Invalid payload: %q", msg.Payload)
+	}
+}
+
+func TestNATSAuthorizationViolation(t *testing.T) {
+	listener, _ := fakeServer(t, "other")
+	defer listener.Close()
+	output := newNATS(t, listener.Addr().String(), "json")
+	err := output.Connect()
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("Invalid error: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The OTLP metrics messages used by the output, from
+// opentelemetry/proto/collector/metrics/v1/metrics_service.proto.
+// The JSON tags follow the OTLP/JSON encoding, and the protobuf encoding
+// is written by hand with the field numbers of the proto files.
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE
+const aggregationTemporalityCumulative = 2
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// ExportMetricsServiceRequest is the body of the /v1/metrics requests
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []*ResourceMetrics `json:"resourceMetrics"`
+}
+
+// ResourceMetrics are the metrics of a resource, the box
+type ResourceMetrics struct {
+	Resource     *Resource       `json:"resource"`
+	ScopeMetrics []*ScopeMetrics `json:"scopeMetrics"`
+}
+
+// Resource describes the entity producing the metrics
+type Resource struct {
+	Attributes []*KeyValue `json:"attributes"`
+}
+
+// ScopeMetrics are the metrics produced by an instrumentation scope
+type ScopeMetrics struct {
+	Scope   *InstrumentationScope `json:"scope"`
+	Metrics []*Metric             `json:"metrics"`
+}
+
+// InstrumentationScope is the library producing the metrics
+type InstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// KeyValue is an attribute
+type KeyValue struct {
+	Key   string    `json:"key"`
+	Value *AnyValue `json:"value"`
+}
+
+// AnyValue is an attribute value. Only strings are used.
+type AnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// Metric is a gauge or a sum
+type Metric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Gauge *Gauge `json:"gauge,omitempty"`
+	Sum   *Sum   `json:"sum,omitempty"`
+}
+
+// Gauge is a metric sampling a current value
+type Gauge struct {
+	DataPoints []*NumberDataPoint `json:"dataPoints"`
+}
+
+// Sum is a metric of a total
+type Sum struct {
+	DataPoints             []*NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                `json:"aggregationTemporality"`
+	IsMonotonic            bool               `json:"isMonotonic"`
+}
+
+// NumberDataPoint is a value of a metric, an integer or a double
+type NumberDataPoint struct {
+	Attributes        []*KeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64      `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64      `json:"timeUnixNano,string"`
+	AsDouble          *float64    `json:"asDouble,omitempty"`
+	AsInt             *int64      `json:"asInt,string,omitempty"`
+}
+
+// protoBuffer encodes protobuf messages
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(v uint64) {
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *protoBuffer) key(field int, wire int) {
+	b.varint(uint64(field<<3 | wire))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	if s != "" {
+		b.bytes(field, []byte(s))
+	}
+}
+
+func (b *protoBuffer) uint(field int, v uint64) {
+	if v != 0 {
+		b.key(field, wireVarint)
+		b.varint(v)
+	}
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uint(field, 1)
+	}
+}
+
+func (b *protoBuffer) fixed64(field int, v uint64) {
+	b.key(field, wireFixed64)
+	*b = binary.LittleEndian.AppendUint64(*b, v)
+}
+
+// message encodes an embedded message, even if it is empty
+func (b *protoBuffer) message(field int, m interface{ marshal() []byte }) {
+	b.bytes(field, m.marshal())
+}
+
+func (r *ExportMetricsServiceRequest) marshal() []byte {
+	var b protoBuffer
+	for _, rm := range r.ResourceMetrics {
+		b.message(1, rm)
+	}
+	return b
+}
+
+func (r *ResourceMetrics) marshal() []byte {
+	var b protoBuffer
+	if r.Resource != nil {
+		b.message(1, r.Resource)
+	}
+	for _, sm := range r.ScopeMetrics {
+		b.message(2, sm)
+	}
+	return b
+}
+
+func (r *Resource) marshal() []byte {
+	var b protoBuffer
+	for _, kv := range r.Attributes {
+		b.message(1, kv)
+	}
+	return b
+}
+
+func (s *ScopeMetrics) marshal() []byte {
+	var b protoBuffer
+	if s.Scope != nil {
+		b.message(1, s.Scope)
+	}
+	for _, m := range s.Metrics {
+		b.message(2, m)
+	}
+	return b
+}
+
+func (s *InstrumentationScope) marshal() []byte {
+	var b protoBuffer
+	b.string(1, s.Name)
+	b.string(2, s.Version)
+	return b
+}
+
+func (kv *KeyValue) marshal() []byte {
+	var b protoBuffer
+	b.string(1, kv.Key)
+	if kv.Value != nil {
+		b.message(2, kv.Value)
+	}
+	return b
+}
+
+func (v *AnyValue) marshal() []byte {
+	var b protoBuffer
+	// string_value is part of a oneof, so it is set even if empty
+	b.bytes(1, []byte(v.StringValue))
+	return b
+}
+
+func (m *Metric) marshal() []byte {
+	var b protoBuffer
+	b.string(1, m.Name)
+	b.string(3, m.Unit)
+	if m.Gauge != nil {
+		b.message(5, m.Gauge)
+	}
+	if m.Sum != nil {
+		b.message(7, m.Sum)
+	}
+	return b
+}
+
+func (g *Gauge) marshal() []byte {
+	var b protoBuffer
+	for _, dp := range g.DataPoints {
+		b.message(1, dp)
+	}
+	return b
+}
+
+func (s *Sum) marshal() []byte {
+	var b protoBuffer
+	for _, dp := range s.DataPoints {
+		b.message(1, dp)
+	}
+	b.uint(2, uint64(s.AggregationTemporality))
+	b.bool(3, s.IsMonotonic)
+	return b
+}
+
+func (dp *NumberDataPoint) marshal() []byte {
+	var b protoBuffer
+	if dp.StartTimeUnixNano != 0 {
+		b.fixed64(2, dp.StartTimeUnixNano)
+	}
+	b.fixed64(3, dp.TimeUnixNano)
+	if dp.AsDouble != nil {
+		b.fixed64(4, math.Float64bits(*dp.AsDouble))
+	}
+	if dp.AsInt != nil {
+		// as_int is a sfixed64
+		b.fixed64(6, uint64(*dp.AsInt))
+	}
+	for _, kv := range dp.Attributes {
+		b.message(7, kv)
+	}
+	return b
+}
@@ -0,0 +1,292 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/version"
+)
+
+const scopeName = "github.com/nlamirault/skybox"
+
+func init() {
+	outputs.Add("otlp", func() outputs.Output {
+		return New()
+	})
+}
+
+// units are the UCUM units of the measurements, or of a measurement field
+var units = map[string]string{
+	"rate":              "By/s",
+	"bytes":             "By",
+	"bandwidth":         "bit/s",
+	"connection.uptime": "s",
+
+	"skybox_collect.duration": "ms",
+	"skybox_write.duration":   "ms",
+}
+
+// OTLP exports the metrics to an OpenTelemetry collector, using OTLP/HTTP
+type OTLP struct {
+	URL      string
+	Encoding string
+	Headers  map[string]string
+	// Counters are the measurements exported as cumulative sums, the
+	// others are gauges
+	Counters   map[string]bool
+	BoxID      string
+	BoxModel   string
+	UserAgent  string
+	HTTPClient *http.Client
+
+	// starts are the start times of the cumulative sums, by series
+	starts map[string]uint64
+	// last are the previous values of the cumulative sums, to detect
+	// resets
+	last map[string]float64
+}
+
+// New returns a OTLP Client
+func New() *OTLP {
+	return &OTLP{
+		Counters:  map[string]bool{},
+		UserAgent: fmt.Sprintf("skybox-otlp-%s", version.Version),
+		starts:    map[string]uint64{},
+		last:      map[string]float64{},
+	}
+}
+
+func (o *OTLP) Setup(config *config.Configuration) error {
+	if config.OTLP == nil {
+		return fmt.Errorf("OTLP configuration not found: %v", config)
+	}
+	switch config.OTLP.Encoding {
+	case "protobuf", "json":
+	default:
+		return fmt.Errorf("OTLP unsupported encoding: %s", config.OTLP.Encoding)
+	}
+	o.URL = config.OTLP.URL
+	o.Encoding = config.OTLP.Encoding
+	o.Headers = config.OTLP.Headers
+	for _, name := range config.OTLP.Counters {
+		o.Counters[name] = true
+	}
+	o.BoxID = config.OTLP.BoxID
+	o.BoxModel = config.OTLP.BoxModel
+	log.Printf("[DEBUG] OTLP output: %s %s", o.URL, o.Encoding)
+	return nil
+}
+
+func (o *OTLP) Connect() error {
+	o.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+func (o *OTLP) Close() error {
+	return nil
+}
+
+// Ping exports an empty request, which the collectors accept
+func (o *OTLP) Ping() error {
+	if o.HTTPClient == nil {
+		return fmt.Errorf("OTLP Client not configured")
+	}
+	return o.export(&ExportMetricsServiceRequest{})
+}
+
+func (o *OTLP) Description() string {
+	return "Configuration for OpenTelemetry collector to send metrics to"
+}
+
+// Write exports the points, with a resource by box
+func (o *OTLP) Write(points []*client.Point) error {
+	if o.HTTPClient == nil {
+		return fmt.Errorf("OTLP Client not configured")
+	}
+	request := o.request(points)
+	if err := o.export(request); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] OTLP Write %d resources", len(request.ResourceMetrics))
+	return nil
+}
+
+// request converts the points to metrics named <measurement>.<field>.
+// The box tag is a resource attribute, the other tags are data point
+// attributes. String fields aren't numeric values, and are skipped.
+func (o *OTLP) request(points []*client.Point) *ExportMetricsServiceRequest {
+	request := &ExportMetricsServiceRequest{}
+	scopes := map[string]*ScopeMetrics{}
+	metrics := map[string]*Metric{}
+	for _, point := range points {
+		tags := point.Tags()
+		box := tags["box"]
+		scope, ok := scopes[box]
+		if !ok {
+			scope = &ScopeMetrics{
+				Scope: &InstrumentationScope{Name: scopeName, Version: version.Version},
+			}
+			scopes[box] = scope
+			request.ResourceMetrics = append(request.ResourceMetrics, &ResourceMetrics{
+				Resource:     &Resource{Attributes: o.resourceAttributes(box)},
+				ScopeMetrics: []*ScopeMetrics{scope},
+			})
+		}
+		attributes := pointAttributes(tags)
+		fields := point.Fields()
+		for _, field := range outputs.FieldNames(fields) {
+			name := point.Name() + "." + field
+			dp := &NumberDataPoint{
+				Attributes:   attributes,
+				TimeUnixNano: uint64(point.Time().UnixNano()),
+			}
+			value, ok := outputs.FieldFloat(fields[field])
+			if !ok {
+				continue
+			}
+			if v, ok := fields[field].(int64); ok {
+				dp.AsInt = &v
+			} else {
+				dp.AsDouble = &value
+			}
+			metric, ok := metrics[box+"/"+name]
+			if !ok {
+				metric = &Metric{Name: "skybox." + name, Unit: unit(point.Name(), field)}
+				if o.Counters[point.Name()] {
+					metric.Sum = &Sum{
+						AggregationTemporality: aggregationTemporalityCumulative,
+						IsMonotonic:            true,
+					}
+				} else {
+					metric.Gauge = &Gauge{}
+				}
+				metrics[box+"/"+name] = metric
+				scope.Metrics = append(scope.Metrics, metric)
+			}
+			if metric.Sum != nil {
+				dp.StartTimeUnixNano = o.startTime(box+"/"+name+"/"+outputs.FormatTags(tags), value, dp.TimeUnixNano)
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, dp)
+			} else {
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, dp)
+			}
+		}
+	}
+	return request
+}
+
+// startTime returns the start time of a cumulative sum series: the time
+// of its first value, or of its last reset
+func (o *OTLP) startTime(series string, value float64, now uint64) uint64 {
+	start, ok := o.starts[series]
+	if !ok || value < o.last[series] {
+		start = now
+		o.starts[series] = start
+	}
+	o.last[series] = value
+	return start
+}
+
+// resourceAttributes describes the box, with the configured identifier
+// and model
+func (o *OTLP) resourceAttributes(box string) []*KeyValue {
+	attributes := []*KeyValue{
+		stringAttribute("service.name", "skybox"),
+		stringAttribute("service.version", version.Version),
+	}
+	if box != "" {
+		attributes = append(attributes, stringAttribute("skybox.box.provider", box))
+	}
+	if o.BoxID != "" {
+		attributes = append(attributes, stringAttribute("skybox.box.id", o.BoxID))
+	}
+	if o.BoxModel != "" {
+		attributes = append(attributes, stringAttribute("skybox.box.model", o.BoxModel))
+	}
+	return attributes
+}
+
+func pointAttributes(tags map[string]string) []*KeyValue {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		if key != "box" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	attributes := make([]*KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, stringAttribute(key, tags[key]))
+	}
+	return attributes
+}
+
+func stringAttribute(key string, value string) *KeyValue {
+	return &KeyValue{Key: key, Value: &AnyValue{StringValue: value}}
+}
+
+func unit(measurement string, field string) string {
+	if unit, ok := units[measurement+"."+field]; ok {
+		return unit
+	}
+	return units[measurement]
+}
+
+func (o *OTLP) export(request *ExportMetricsServiceRequest) error {
+	var body []byte
+	var contentType string
+	if o.Encoding == "json" {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = data
+		contentType = "application/json"
+	} else {
+		body = request.marshal()
+		contentType = "application/x-protobuf"
+	}
+	req, err := http.NewRequest("POST", o.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", o.UserAgent)
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range o.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return &outputs.StatusError{Output: "OTLP", StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return nil
+}
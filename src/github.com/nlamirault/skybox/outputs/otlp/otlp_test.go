@@ -0,0 +1,189 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+type fakeCollector struct {
+	ContentType string
+	Bodies      [][]byte
+}
+
+func (f *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("Authorization") != "Bearer xxxxxxxx" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	f.ContentType = r.Header.Get("Content-Type")
+	f.Bodies = append(f.Bodies, body)
+}
+
+func newOTLP(t *testing.T, url string, encoding string) *OTLP {
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.OTLP.URL = url + "/v1/metrics"
+		conf.OTLP.Encoding = encoding
+		conf.OTLP.Headers = map[string]string{"Authorization": "Bearer xxxxxxxx"}
+		conf.OTLP.BoxID = "freebox-42"
+		conf.OTLP.BoxModel = "Freebox Revolution"
+	})
+	return output
+}
+
+func newPoint(t *testing.T, name string, fields map[string]interface{}, seconds int64) *client.Point {
+	return outputstest.Point(t, name, map[string]string{"box": "freebox"}, fields, time.Unix(seconds, 0))
+}
+
+func TestOTLPJSON(t *testing.T) {
+	fake := &fakeCollector{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output := newOTLP(t, server.URL, "json")
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	points := []*client.Point{
+		newPoint(t, "rate", map[string]interface{}{"down": 1000}, 10),
+		newPoint(t, "bytes", map[string]interface{}{"down": 5000}, 10),
+		newPoint(t, "skybox_collect", map[string]interface{}{"collector": "connection", "duration": 42}, 10),
+	}
+	if err := output.Write(points); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Write([]*client.Point{newPoint(t, "bytes", map[string]interface{}{"down": 8000}, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.ContentType != "application/json" || len(fake.Bodies) != 3 {
+		t.Fatalf("Invalid requests: %s %d", fake.ContentType, len(fake.Bodies))
+	}
+
+	var request ExportMetricsServiceRequest
+	if err := json.Unmarshal(fake.Bodies[1], &request); err != nil {
+		t.Fatal(err)
+	}
+	resource := request.ResourceMetrics[0]
+	attributes := map[string]string{}
+	for _, kv := range resource.Resource.Attributes {
+		attributes[kv.Key] = kv.Value.StringValue
+	}
+	if attributes["skybox.box.id"] != "freebox-42" || attributes["skybox.box.model"] != "Freebox Revolution" ||
+		attributes["skybox.box.provider"] != "freebox" || attributes["service.name"] != "skybox" {
+		t.Fatalf("Invalid resource attributes: %v", attributes)
+	}
+	metrics := resource.ScopeMetrics[0].Metrics
+	// The collector name is a string, which is skipped
+	if len(metrics) != 3 {
+		t.Fatalf("Invalid metrics: %d", len(metrics))
+	}
+	rate := metrics[0]
+	if rate.Name != "skybox.rate.down" || rate.Unit != "By/s" || rate.Gauge == nil ||
+		*rate.Gauge.DataPoints[0].AsInt != 1000 || rate.Gauge.DataPoints[0].TimeUnixNano != 10e9 {
+		t.Fatalf("Invalid rate metric: %#v", rate)
+	}
+	counter := metrics[1]
+	if counter.Name != "skybox.bytes.down" || counter.Sum == nil || !counter.Sum.IsMonotonic ||
+		counter.Sum.AggregationTemporality != aggregationTemporalityCumulative {
+		t.Fatalf("Invalid bytes metric: %#v", counter)
+	}
+	if metrics[2].Name != "skybox.skybox_collect.duration" || metrics[2].Unit != "ms" {
+		t.Fatalf("Invalid collect metric: %#v", metrics[2])
+	}
+
+	// The start time of the cumulative sum is kept between writes
+	if err := json.Unmarshal(fake.Bodies[2], &request); err != nil {
+		t.Fatal(err)
+	}
+	dp := request.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum.DataPoints[0]
+	if dp.StartTimeUnixNano != 10e9 || dp.TimeUnixNano != 20e9 || *dp.AsInt != 8000 {
+		t.Fatalf("Invalid cumulative data point: %#v", dp)
+	}
+}
+
+func TestOTLPCounterReset(t *testing.T) {
+	output := New()
+	output.Counters["bytes"] = true
+	for i, value := range []int{5000, 8000, 100} {
+		request := output.request([]*client.Point{
+			newPoint(t, "bytes", map[string]interface{}{"up": value}, int64(10*(i+1))),
+		})
+		dp := request.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum.DataPoints[0]
+		expected := uint64(10e9)
+		if i == 2 {
+			expected = 30e9
+		}
+		if dp.StartTimeUnixNano != expected {
+			t.Fatalf("Invalid start time %d: %d", i, dp.StartTimeUnixNano)
+		}
+	}
+}
+
+func TestOTLPProtobuf(t *testing.T) {
+	fake := &fakeCollector{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	output := newOTLP(t, server.URL, "protobuf")
+	if err := output.Write([]*client.Point{newPoint(t, "rate", map[string]interface{}{"up": 200}, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.ContentType != "application/x-protobuf" {
+		t.Fatalf("Invalid content type: %s", fake.ContentType)
+	}
+	request := output.request([]*client.Point{newPoint(t, "rate", map[string]interface{}{"up": 200}, 1)})
+	if !bytes.Equal(fake.Bodies[0], request.marshal()) {
+		t.Fatalf("Invalid body: %x", fake.Bodies[0])
+	}
+}
+
+func TestProtobufEncoding(t *testing.T) {
+	value := int64(200)
+	dp := &NumberDataPoint{
+		Attributes:   []*KeyValue{stringAttribute("k", "v")},
+		TimeUnixNano: 1e9,
+		AsInt:        &value,
+	}
+	expected := []byte{
+		// time_unix_nano
+		0x19, 0x00, 0xca, 0x9a, 0x3b, 0x00, 0x00, 0x00, 0x00,
+		// as_int
+		0x31, 0xc8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// attributes
+		0x3a, 0x08, 0x0a, 0x01, 'k', 0x12, 0x03, 0x0a, 0x01, 'v',
+	}
+	if data := dp.marshal(); !bytes.Equal(data, expected) {
+		t.Fatalf("Invalid data point encoding: %x", data)
+	}
+
+	sum := &Sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+	metric := &Metric{Name: "m", Unit: "s", Sum: sum}
+	expected = []byte{0x0a, 0x01, 'm', 0x1a, 0x01, 's', 0x3a, 0x04, 0x10, 0x02, 0x18, 0x01}
+	if data := metric.marshal(); !bytes.Equal(data, expected) {
+		t.Fatalf("Invalid metric encoding: %x", data)
+	}
+}
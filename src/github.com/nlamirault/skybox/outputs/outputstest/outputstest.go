@@ -0,0 +1,73 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package outputstest provides the points and the setup shared by the
+// outputs tests.
+package outputstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+)
+
+// Time is the time of the points: 2016-02-01T00:00:00Z
+var Time = time.Unix(1454284800, 0)
+
+// Output is the part of an output set up by the tests
+type Output interface {
+	Setup(conf *config.Configuration) error
+	Connect() error
+}
+
+// Point returns a new point, or fails the test
+func Point(t testing.TB, name string, tags map[string]string, fields map[string]interface{}, at time.Time) *client.Point {
+	pt, err := client.NewPoint(name, tags, fields, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pt
+}
+
+// Rates returns a rate point, up 200 and down 1000, for each box
+func Rates(t testing.TB, at time.Time, boxes ...string) []*client.Point {
+	var points []*client.Point
+	for _, box := range boxes {
+		points = append(points, Point(t, "rate",
+			map[string]string{"box": box},
+			map[string]interface{}{"up": 200, "down": 1000}, at))
+	}
+	return points
+}
+
+// Setup sets the output up with the default configuration changed by setup,
+// or fails the test
+func Setup(t testing.TB, output Output, setup func(*config.Configuration)) {
+	conf := config.New()
+	setup(conf)
+	if err := output.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Connect sets the output up like Setup then connects it, or fails the test
+func Connect(t testing.TB, output Output, setup func(*config.Configuration)) {
+	Setup(t, output, setup)
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+}
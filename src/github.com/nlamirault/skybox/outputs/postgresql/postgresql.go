@@ -0,0 +1,191 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgresql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/lib/pq"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+var validTable = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func init() {
+	outputs.Add("postgresql", func() outputs.Output {
+		return New()
+	})
+}
+
+// PostgreSQL writes the metrics into a PostgreSQL table, optionally a
+// TimescaleDB hypertable
+type PostgreSQL struct {
+	URL         string
+	Table       string
+	TimescaleDB bool
+	DB          *sql.DB
+}
+
+// New returns a PostgreSQL output
+func New() *PostgreSQL {
+	return &PostgreSQL{}
+}
+
+func (p *PostgreSQL) Setup(config *config.Configuration) error {
+	if config.PostgreSQL == nil {
+		return fmt.Errorf("PostgreSQL configuration not found: %v", config)
+	}
+	if !validTable.MatchString(config.PostgreSQL.Table) {
+		return fmt.Errorf("PostgreSQL invalid table: %s", config.PostgreSQL.Table)
+	}
+	p.URL = config.PostgreSQL.URL
+	p.Table = config.PostgreSQL.Table
+	p.TimescaleDB = config.PostgreSQL.TimescaleDB
+	log.Printf("[DEBUG] PostgreSQL output: table %s", p.Table)
+	return nil
+}
+
+func (p *PostgreSQL) Connect() error {
+	log.Printf("[DEBUG] PostgreSQL Connect")
+	db, err := sql.Open("postgres", p.URL)
+	if err != nil {
+		return err
+	}
+	// Create the table if it doesn't exist
+	log.Printf("[DEBUG] PostgreSQL Create table if not exists: %s", p.Table)
+	for _, statement := range schema(p.Table, p.TimescaleDB) {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	p.DB = db
+	return nil
+}
+
+func (p *PostgreSQL) Close() error {
+	if p.DB == nil {
+		return nil
+	}
+	err := p.DB.Close()
+	p.DB = nil
+	return err
+}
+
+func (p *PostgreSQL) Ping() error {
+	if p.DB == nil {
+		return fmt.Errorf("PostgreSQL Client not configured")
+	}
+	return p.DB.Ping()
+}
+
+func (p *PostgreSQL) Description() string {
+	return "Configuration for PostgreSQL server to send metrics to"
+}
+
+// Write inserts the metrics using COPY in a transaction
+func (p *PostgreSQL) Write(points []*client.Point) error {
+	if p.DB == nil {
+		return fmt.Errorf("PostgreSQL Client not configured")
+	}
+	rows, err := metrics(points)
+	if err != nil {
+		return err
+	}
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(p.Table, "time", "measurement", "tags", "field", "value"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	// Flush the COPY buffer
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	log.Printf("[DEBUG] PostgreSQL Write %d metrics", len(rows))
+	return tx.Commit()
+}
+
+// schema returns the statements creating the table
+func schema(table string, timescaleDB bool) []string {
+	quoted := pq.QuoteIdentifier(table)
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	time TIMESTAMPTZ NOT NULL,
+	measurement TEXT NOT NULL,
+	tags JSONB NOT NULL,
+	field TEXT NOT NULL,
+	value DOUBLE PRECISION NOT NULL
+)`, quoted),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (measurement, field, time DESC)",
+			pq.QuoteIdentifier(table+"_measurement_time"), quoted),
+	}
+	if timescaleDB {
+		statements = append(statements,
+			"CREATE EXTENSION IF NOT EXISTS timescaledb",
+			fmt.Sprintf("SELECT create_hypertable(%s, 'time', if_not_exists => TRUE)",
+				pq.QuoteLiteral(table)))
+	}
+	return statements
+}
+
+// metrics returns the COPY rows of the numeric fields of the points
+func metrics(points []*client.Point) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, point := range points {
+		tags, err := json.Marshal(point.Tags())
+		if err != nil {
+			return nil, err
+		}
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			value, ok := outputs.FieldFloat(fields[name])
+			if !ok {
+				continue
+			}
+			rows = append(rows, []interface{}{
+				point.Time(),
+				point.Name(),
+				string(tags),
+				name,
+				value,
+			})
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgresql
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newPoints(t *testing.T) []*client.Point {
+	return []*client.Point{outputstest.Point(t, "connection",
+		map[string]string{"box": "freebox"},
+		map[string]interface{}{"state": "up", "uptime": 3600}, time.Now())}
+}
+
+func TestPostgreSQLSchema(t *testing.T) {
+	statements := schema("skybox_metrics", true)
+	if len(statements) != 4 ||
+		!strings.HasPrefix(statements[0], `CREATE TABLE IF NOT EXISTS "skybox_metrics"`) ||
+		statements[3] != `SELECT create_hypertable('skybox_metrics', 'time', if_not_exists => TRUE)` {
+		t.Fatalf("Invalid schema: %v", statements)
+	}
+	if len(schema("skybox_metrics", false)) != 2 {
+		t.Fatalf("TimescaleDB statements without TimescaleDB")
+	}
+}
+
+func TestPostgreSQLMetrics(t *testing.T) {
+	rows, err := metrics(newPoints(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The state isn't a numeric value
+	if len(rows) != 1 || rows[0][1] != "connection" || rows[0][2] != `{"box":"freebox"}` ||
+		rows[0][3] != "uptime" || rows[0][4] != 3600.0 {
+		t.Fatalf("Invalid rows: %v", rows)
+	}
+}
+
+func TestPostgreSQLInvalidTable(t *testing.T) {
+	conf := config.New()
+	conf.PostgreSQL.Table = "metrics; DROP TABLE users"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with an invalid table")
+	}
+}
+
+// TestPostgreSQLWrite requires a PostgreSQL server, using the connection
+// string of SKYBOX_POSTGRESQL_URL
+func TestPostgreSQLWrite(t *testing.T) {
+	url := os.Getenv("SKYBOX_POSTGRESQL_URL")
+	if url == "" {
+		t.Skip("SKYBOX_POSTGRESQL_URL not set")
+	}
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.PostgreSQL.URL = url
+		conf.PostgreSQL.Table = "skybox_metrics_test"
+	})
+	defer output.Close()
+	defer output.DB.Exec("DROP TABLE skybox_metrics_test")
+	if err := output.Write(newPoints(t)); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := output.DB.QueryRow("SELECT COUNT(*) FROM skybox_metrics_test WHERE tags->>'box' = 'freebox'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("Invalid metrics count: %d", count)
+	}
+}
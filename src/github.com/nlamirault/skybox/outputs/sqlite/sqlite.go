@@ -0,0 +1,228 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+// pruneInterval is the delay between two deletions of the expired samples
+const pruneInterval = time.Hour
+
+// rollup is a table of aggregated samples
+type rollup struct {
+	Table      string
+	Resolution int64
+}
+
+var rollups = []rollup{
+	{"rollups_hourly", 3600},
+	{"rollups_daily", 86400},
+}
+
+const samplesSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	time INTEGER NOT NULL,
+	measurement TEXT NOT NULL,
+	tags TEXT NOT NULL,
+	field TEXT NOT NULL,
+	value REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (measurement, field, time);
+`
+
+const rollupSchema = `
+CREATE TABLE IF NOT EXISTS %s (
+	time INTEGER NOT NULL,
+	measurement TEXT NOT NULL,
+	tags TEXT NOT NULL,
+	field TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	sum REAL NOT NULL,
+	min REAL NOT NULL,
+	max REAL NOT NULL,
+	PRIMARY KEY (measurement, field, tags, time)
+);
+`
+
+const rollupUpsert = `
+INSERT INTO %s (time, measurement, tags, field, count, sum, min, max)
+VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+ON CONFLICT (measurement, field, tags, time) DO UPDATE SET
+	count = count + 1,
+	sum = sum + excluded.sum,
+	min = MIN(min, excluded.min),
+	max = MAX(max, excluded.max)
+`
+
+func init() {
+	outputs.Add("sqlite", func() outputs.Output {
+		return New()
+	})
+}
+
+// SQLite stores the samples into a SQLite database, with hourly and daily
+// rollups
+type SQLite struct {
+	Path string
+	// Retention is the duration of the raw samples. Forever if zero.
+	Retention time.Duration
+	DB        *sql.DB
+	// Now returns the current time
+	Now func() time.Time
+
+	pruned time.Time
+}
+
+// New returns a SQLite output
+func New() *SQLite {
+	return &SQLite{
+		Now: time.Now,
+	}
+}
+
+func (s *SQLite) Setup(config *config.Configuration) error {
+	if config.SQLite == nil || config.SQLite.Path == "" {
+		return fmt.Errorf("SQLite configuration not found: %v", config)
+	}
+	s.Path = config.SQLite.Path
+	s.Retention = time.Duration(config.SQLite.RetentionDays) * 24 * time.Hour
+	log.Printf("[DEBUG] SQLite output: %v", s)
+	return nil
+}
+
+func (s *SQLite) Connect() error {
+	log.Printf("[DEBUG] SQLite open: %s", s.Path)
+	db, err := sql.Open("sqlite3", s.Path)
+	if err != nil {
+		return err
+	}
+	// SQLite supports one writer at a time
+	db.SetMaxOpenConns(1)
+	schema := samplesSchema
+	for _, r := range rollups {
+		schema += fmt.Sprintf(rollupSchema, r.Table)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return err
+	}
+	s.DB = db
+	return nil
+}
+
+func (s *SQLite) Close() error {
+	if s.DB == nil {
+		return nil
+	}
+	err := s.DB.Close()
+	s.DB = nil
+	return err
+}
+
+func (s *SQLite) Ping() error {
+	if s.DB == nil {
+		return fmt.Errorf("SQLite database not opened")
+	}
+	return s.DB.Ping()
+}
+
+func (s *SQLite) Description() string {
+	return "Configuration for SQLite database to store metrics into"
+}
+
+// Write inserts the samples and updates the rollups in a transaction
+func (s *SQLite) Write(points []*client.Point) error {
+	if s.DB == nil {
+		return fmt.Errorf("SQLite database not opened")
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := s.insert(tx, points); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] SQLite Write %d points", len(points))
+	return s.prune()
+}
+
+func (s *SQLite) insert(tx *sql.Tx, points []*client.Point) error {
+	insert, err := tx.Prepare("INSERT INTO samples (time, measurement, tags, field, value) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	upserts := make([]*sql.Stmt, len(rollups))
+	for i, r := range rollups {
+		upserts[i], err = tx.Prepare(fmt.Sprintf(rollupUpsert, r.Table))
+		if err != nil {
+			return err
+		}
+		defer upserts[i].Close()
+	}
+	for _, point := range points {
+		timestamp := point.Time().Unix()
+		tags := outputs.FormatTags(point.Tags())
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			value, ok := outputs.FieldFloat(fields[name])
+			if !ok {
+				continue
+			}
+			if _, err := insert.Exec(timestamp, point.Name(), tags, name, value); err != nil {
+				return err
+			}
+			for i, r := range rollups {
+				bucket := timestamp - timestamp%r.Resolution
+				_, err := upserts[i].Exec(bucket, point.Name(), tags, name, value, value, value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// prune deletes the samples older than the retention
+func (s *SQLite) prune() error {
+	now := s.Now()
+	if s.Retention == 0 || now.Sub(s.pruned) < pruneInterval {
+		return nil
+	}
+	result, err := s.DB.Exec("DELETE FROM samples WHERE time < ?", now.Add(-s.Retention).Unix())
+	if err != nil {
+		return err
+	}
+	s.pruned = now
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Printf("[INFO] SQLite deleted %d expired samples", n)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newPoints(t *testing.T, now time.Time, rateDown int) []*client.Point {
+	return []*client.Point{outputstest.Point(t, "rate",
+		map[string]string{"box": "freebox"},
+		map[string]interface{}{"up": 200, "down": rateDown}, now)}
+}
+
+func TestSQLiteRollupsAndRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skybox-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := New()
+	outputstest.Setup(t, output, func(conf *config.Configuration) {
+		conf.SQLite.Path = filepath.Join(dir, "skybox.db")
+		conf.SQLite.RetentionDays = 1
+	})
+	now := time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC)
+	output.Now = func() time.Time { return now }
+	if err := output.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if err := output.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, rate := range []int{1000, 3000, 2000} {
+		at := now.Add(time.Duration(i*20) * time.Minute)
+		if err := output.Write(newPoints(t, at, rate)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	var sum, min, max float64
+	err = output.DB.QueryRow(`SELECT count, sum, min, max FROM rollups_hourly
+		WHERE measurement = 'rate' AND field = 'down' AND tags = 'box=freebox' AND time = ?`,
+		now.Unix()).Scan(&count, &sum, &min, &max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || sum != 6000 || min != 1000 || max != 3000 {
+		t.Fatalf("Invalid hourly rollup: %d %f %f %f", count, sum, min, max)
+	}
+
+	// Raw samples are deleted after the retention, the rollups are kept
+	now = now.Add(48 * time.Hour)
+	if err := output.Write(newPoints(t, now, 500)); err != nil {
+		t.Fatal(err)
+	}
+	output.DB.QueryRow("SELECT COUNT(*) FROM samples").Scan(&count)
+	if count != 2 {
+		t.Fatalf("Expired samples not deleted: %d", count)
+	}
+	output.DB.QueryRow("SELECT COUNT(*) FROM rollups_daily WHERE field = 'down'").Scan(&count)
+	if count != 2 {
+		t.Fatalf("Invalid daily rollups: %d", count)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+)
+
+// defaultPacketSize fits in an Ethernet MTU with IP and UDP headers
+const defaultPacketSize = 1432
+
+var invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-.]+`)
+
+func init() {
+	outputs.Add("statsd", func() outputs.Output {
+		return New()
+	})
+}
+
+// StatsD sends gauges and counters to a StatsD server
+type StatsD struct {
+	Address    string
+	Prefix     string
+	DogStatsD  bool
+	PacketSize int
+	// Counters are the measurements sent as counters, the others are gauges
+	Counters map[string]bool
+	Conn     net.Conn
+
+	// last values of the counters, used to send increments
+	last map[string]float64
+}
+
+// New returns a StatsD Client
+func New() *StatsD {
+	return &StatsD{
+		PacketSize: defaultPacketSize,
+		Counters:   map[string]bool{},
+		last:       map[string]float64{},
+	}
+}
+
+func (s *StatsD) Setup(config *config.Configuration) error {
+	if config.StatsD == nil {
+		return fmt.Errorf("StatsD configuration not found: %v", config)
+	}
+	s.Address = config.StatsD.Address
+	s.Prefix = config.StatsD.Prefix
+	s.DogStatsD = config.StatsD.DogStatsD
+	if config.StatsD.PacketSize > 0 {
+		s.PacketSize = config.StatsD.PacketSize
+	}
+	for _, name := range config.StatsD.Counters {
+		s.Counters[name] = true
+	}
+	log.Printf("[DEBUG] StatsD output: %v", s)
+	return nil
+}
+
+func (s *StatsD) Connect() error {
+	log.Printf("[DEBUG] StatsD Connect: %s", s.Address)
+	conn, err := net.Dial("udp", s.Address)
+	if err != nil {
+		return err
+	}
+	s.Conn = conn
+	return nil
+}
+
+func (s *StatsD) Close() error {
+	if s.Conn == nil {
+		return nil
+	}
+	err := s.Conn.Close()
+	s.Conn = nil
+	return err
+}
+
+func (s *StatsD) Ping() error {
+	if s.Conn == nil {
+		return fmt.Errorf("StatsD Client not configured")
+	}
+	return nil
+}
+
+func (s *StatsD) Description() string {
+	return "Configuration for StatsD server to send metrics to"
+}
+
+func (s *StatsD) Write(points []*client.Point) error {
+	if s.Conn == nil {
+		return fmt.Errorf("StatsD Client not configured")
+	}
+	lines, counters := s.lines(points)
+	packets := s.packets(lines)
+	log.Printf("[DEBUG] StatsD Write %d packets", len(packets))
+	index := 0
+	for _, packet := range packets {
+		if _, err := s.Conn.Write(packet); err != nil {
+			return err
+		}
+		// The increments of the packet are sent: the next ones start
+		// from these values
+		n := bytes.Count(packet, []byte{'\n'}) + 1
+		for i := index; i < index+n; i++ {
+			if c, ok := counters[i]; ok {
+				s.last[c.Key] = c.Value
+			}
+		}
+		index += n
+	}
+	return nil
+}
+
+// counter is the value of a counter, recorded once its increment is sent
+type counter struct {
+	Key   string
+	Value float64
+}
+
+// lines returns the StatsD lines of the numeric fields of the points.
+// Counters are sent as the increment since the previous sent value: the
+// counter values are returned by line index, to be recorded once sent.
+func (s *StatsD) lines(points []*client.Point) ([]string, map[int]counter) {
+	var lines []string
+	counters := map[int]counter{}
+	for _, point := range points {
+		tags := s.tags(point.Tags())
+		fields := point.Fields()
+		for _, name := range outputs.FieldNames(fields) {
+			value, ok := outputs.FieldFloat(fields[name])
+			if !ok {
+				continue
+			}
+			metric := s.name(point.Name(), name)
+			metricType := "g"
+			if s.Counters[point.Name()] {
+				key := metric + tags
+				last, ok := s.last[key]
+				// Skip the first value and the counters resets
+				if !ok || value < last {
+					s.last[key] = value
+					continue
+				}
+				counters[len(lines)] = counter{Key: key, Value: value}
+				value = value - last
+				metricType = "c"
+			}
+			lines = append(lines, fmt.Sprintf("%s:%s|%s%s",
+				metric, strconv.FormatFloat(value, 'f', -1, 64), metricType, tags))
+		}
+	}
+	return lines, counters
+}
+
+func (s *StatsD) name(measurement string, field string) string {
+	parts := []string{measurement, field}
+	if s.Prefix != "" {
+		parts = append([]string{s.Prefix}, parts...)
+	}
+	return invalidChars.ReplaceAllString(strings.Join(parts, "."), "_")
+}
+
+// tags returns the DogStatsD tags suffix, or nothing with plain StatsD
+func (s *StatsD) tags(tags map[string]string) string {
+	if !s.DogStatsD || len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(tags))
+	for _, key := range keys {
+		values = append(values, dogStatsDTag(key)+":"+dogStatsDTag(tags[key]))
+	}
+	return "|#" + strings.Join(values, ",")
+}
+
+func dogStatsDTag(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", ":", "_").Replace(s)
+}
+
+// packets batches the lines into packets up to the packet size
+func (s *StatsD) packets(lines []string) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > s.PacketSize {
+			packets = append(packets, append([]byte{}, buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newPoints(t *testing.T, bytesDown int) []*client.Point {
+	return append(outputstest.Rates(t, time.Now(), "freebox"),
+		outputstest.Point(t, "bytes", map[string]string{"box": "freebox"},
+			map[string]interface{}{"up": 700, "down": bytesDown}, time.Now()))
+}
+
+func newStatsD(t *testing.T, setup func(*config.StatsDConfiguration)) (*StatsD, net.PacketConn) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New()
+	outputstest.Connect(t, s, func(conf *config.Configuration) {
+		conf.StatsD.Address = server.LocalAddr().String()
+		setup(conf.StatsD)
+	})
+	return s, server
+}
+
+func receive(t *testing.T, server net.PacketConn) string {
+	buf := make([]byte, 65536)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Packet not received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsDGaugesAndCounters(t *testing.T) {
+	s, server := newStatsD(t, func(conf *config.StatsDConfiguration) {})
+	defer server.Close()
+	defer s.Close()
+
+	if err := s.Write(newPoints(t, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	// No counters increments on the first write
+	if packet := receive(t, server); packet != "skybox.rate.down:1000|g\nskybox.rate.up:200|g" {
+		t.Fatalf("Invalid packet: %q", packet)
+	}
+	if err := s.Write(newPoints(t, 8000)); err != nil {
+		t.Fatal(err)
+	}
+	packet := receive(t, server)
+	if !strings.HasSuffix(packet, "\nskybox.bytes.down:3000|c\nskybox.bytes.up:0|c") {
+		t.Fatalf("Invalid packet: %q", packet)
+	}
+}
+
+func TestStatsDDogStatsDTags(t *testing.T) {
+	s, server := newStatsD(t, func(conf *config.StatsDConfiguration) {
+		conf.DogStatsD = true
+		conf.Counters = nil
+	})
+	defer server.Close()
+	defer s.Close()
+
+	if err := s.Write(newPoints(t, 5000)[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if packet := receive(t, server); packet != "skybox.bytes.down:5000|g|#box:freebox\nskybox.bytes.up:700|g|#box:freebox" {
+		t.Fatalf("Invalid packet: %q", packet)
+	}
+}
+
+func TestStatsDPacketSize(t *testing.T) {
+	s := New()
+	s.PacketSize = 20
+	packets := s.packets([]string{"a.b:1|g", "a.c:2|g", "a.d:3|g", "a.very.long.metric:4|g"})
+	if len(packets) != 3 || string(packets[0]) != "a.b:1|g\na.c:2|g" ||
+		string(packets[1]) != "a.d:3|g" {
+		t.Fatalf("Invalid packets: %q", packets)
+	}
+}
+
+func TestStatsDCounterNotSent(t *testing.T) {
+	s, server := newStatsD(t, func(conf *config.StatsDConfiguration) {})
+	defer server.Close()
+
+	if err := s.Write(newPoints(t, 5000)); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, server)
+	// The increment of a failed write is sent with the next one
+	conn := s.Conn
+	conn.Close()
+	if err := s.Write(newPoints(t, 8000)); err == nil {
+		t.Fatalf("No error with the connection closed")
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Write(newPoints(t, 9000)); err != nil {
+		t.Fatal(err)
+	}
+	packet := receive(t, server)
+	if !strings.HasSuffix(packet, "\nskybox.bytes.down:4000|c\nskybox.bytes.up:0|c") {
+		t.Fatalf("Invalid packet: %q", packet)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/version"
+)
+
+// defaultTemplate sends the points as a JSON array
+const defaultTemplate = "{{json .Points}}"
+
+func init() {
+	outputs.Add("webhook", func() outputs.Output {
+		return New()
+	})
+}
+
+// Batch is the data of the body template
+type Batch struct {
+	Points []outputs.Record
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Webhook sends the points to an HTTP endpoint, with a body built from a
+// template
+type Webhook struct {
+	URL         string
+	Method      string
+	ContentType string
+	Headers     map[string]string
+	Username    string
+	Password    string
+	Token       string
+	Template    *template.Template
+	// Retries is the number of retries on server errors
+	Retries    int
+	RetryDelay time.Duration
+	UserAgent  string
+	HTTPClient *http.Client
+
+	// interruption aborts the waits between the retries
+	interruption *outputs.Interruption
+}
+
+// New returns a Webhook Client
+func New() *Webhook {
+	return &Webhook{
+		UserAgent:    fmt.Sprintf("skybox-webhook-%s", version.Version),
+		interruption: outputs.NewInterruption(),
+	}
+}
+
+func (w *Webhook) Setup(config *config.Configuration) error {
+	if config.Webhook == nil || config.Webhook.URL == "" {
+		return fmt.Errorf("Webhook configuration not found: %v", config)
+	}
+	body := config.Webhook.Template
+	if body == "" {
+		body = defaultTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return fmt.Errorf("Webhook invalid template: %s", err.Error())
+	}
+	w.URL = config.Webhook.URL
+	w.Method = config.Webhook.Method
+	if w.Method == "" {
+		w.Method = "POST"
+	}
+	w.ContentType = config.Webhook.ContentType
+	w.Headers = config.Webhook.Headers
+	w.Username = config.Webhook.Username
+	w.Password = config.Webhook.Password
+	w.Token = config.Webhook.Token
+	w.Template = tmpl
+	w.Retries = config.Webhook.Retries
+	w.RetryDelay = time.Duration(config.Webhook.RetryDelay) * time.Second
+	log.Printf("[DEBUG] Webhook output: %s %s", w.Method, w.URL)
+	return nil
+}
+
+func (w *Webhook) Connect() error {
+	w.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+func (w *Webhook) Close() error {
+	return nil
+}
+
+// Ping checks the endpoint is reachable. Any HTTP response is fine, as
+// the endpoint could accept only the webhook method.
+func (w *Webhook) Ping() error {
+	if w.HTTPClient == nil {
+		return fmt.Errorf("Webhook Client not configured")
+	}
+	req, err := http.NewRequest("HEAD", w.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Interrupt aborts the wait before the next retry, and the next retries
+func (w *Webhook) Interrupt() {
+	w.interruption.Interrupt()
+}
+
+func (w *Webhook) Description() string {
+	return "Configuration for HTTP endpoint to send metrics to"
+}
+
+// Write sends the batch, and retries on server errors until interrupted
+func (w *Webhook) Write(points []*client.Point) error {
+	if w.HTTPClient == nil {
+		return fmt.Errorf("Webhook Client not configured")
+	}
+	batch := Batch{}
+	for _, point := range points {
+		batch.Points = append(batch.Points, outputs.NewRecord(point))
+	}
+	var body bytes.Buffer
+	if err := w.Template.Execute(&body, batch); err != nil {
+		return err
+	}
+	var err error
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("[WARN] Webhook retry %d/%d: %s", attempt, w.Retries, err.Error())
+			if !w.interruption.Wait(time.Duration(attempt) * w.RetryDelay) {
+				return err
+			}
+		}
+		var retry bool
+		retry, err = w.send(body.Bytes())
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send performs the request, and returns if it could be retried on error
+func (w *Webhook) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(w.Method, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", w.UserAgent)
+	if w.ContentType != "" {
+		req.Header.Set("Content-Type", w.ContentType)
+	}
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	} else if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		err := &outputs.StatusError{Output: "Webhook", StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		return resp.StatusCode >= 500, err
+	}
+	log.Printf("[DEBUG] Webhook Write response: %d", resp.StatusCode)
+	return false, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/outputs"
+	"github.com/nlamirault/skybox/outputs/outputstest"
+)
+
+func newWebhook(t *testing.T, url string, setup func(*config.WebhookConfiguration)) *Webhook {
+	output := New()
+	outputstest.Connect(t, output, func(conf *config.Configuration) {
+		conf.Webhook.URL = url
+		conf.Webhook.RetryDelay = 0
+		setup(conf.Webhook)
+	})
+	return output
+}
+
+func TestWebhookDefaultTemplate(t *testing.T) {
+	var body []byte
+	var request *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	output := newWebhook(t, server.URL, func(conf *config.WebhookConfiguration) {
+		conf.Token = "xxxxxxxx"
+		conf.Headers = map[string]string{"X-Source": "skybox"}
+	})
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	if request.Header.Get("Authorization") != "Bearer xxxxxxxx" ||
+		request.Header.Get("X-Source") != "skybox" ||
+		request.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid headers: %v", request.Header)
+	}
+	var points []outputs.Record
+	if err := json.Unmarshal(body, &points); err != nil {
+		t.Fatalf("Invalid body: %s", body)
+	}
+	if len(points) != 1 || points[0].Measurement != "rate" || points[0].Fields["down"] != 1000.0 {
+		t.Fatalf("Invalid points: %v", points)
+	}
+}
+
+func TestWebhookTemplateAndRetries(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "skybox" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	output := newWebhook(t, server.URL, func(conf *config.WebhookConfiguration) {
+		conf.Username = "skybox"
+		conf.Password = "secret"
+		conf.ContentType = "text/plain"
+		conf.Template = `{{range .Points}}{{.Measurement}} {{.Tags.box}} {{index .Fields "down"}} {{.Time.Unix}}{{end}}`
+	})
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 3 || bodies[2] != "rate freebox 1000 1454284800" {
+		t.Fatalf("Invalid requests: %v", bodies)
+	}
+}
+
+func TestWebhookClientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	output := newWebhook(t, server.URL, func(conf *config.WebhookConfiguration) {})
+	if err := output.Write(outputstest.Rates(t, outputstest.Time, "freebox")); err == nil || requests != 1 {
+		t.Fatalf("Client errors must not be retried: %v %d", err, requests)
+	}
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	conf := config.New()
+	conf.Webhook.URL = "http://localhost"
+	conf.Webhook.Template = "{{range .Points}"
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with an invalid template")
+	}
+}
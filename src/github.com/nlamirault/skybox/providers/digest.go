@@ -0,0 +1,240 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DigestTransport is an http.RoundTripper which performs
+// HTTP Digest Access Authentication (RFC 2617)
+type DigestTransport struct {
+	Username string
+	Password string
+	// Transport is the underlying transport. http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	count     int
+}
+
+type digestChallenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       string
+}
+
+// NewDigestTransport returns a DigestTransport for these credentials
+func NewDigestTransport(username, password string) *DigestTransport {
+	return &DigestTransport{
+		Username: username,
+		Password: password,
+	}
+}
+
+func (t *DigestTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip sends the request, and replies to the digest challenge if the
+// server asks for authentication.
+func (t *DigestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		content, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		body = content
+	}
+
+	t.mu.Lock()
+	challenge := t.challenge
+	t.mu.Unlock()
+	if challenge != nil {
+		resp, err := t.send(req, body, challenge)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		return t.retry(req, body, resp)
+	}
+	resp, err := t.transport().RoundTrip(copyRequest(req, body, ""))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	return t.retry(req, body, resp)
+}
+
+// retry parse the challenge of an unauthorized response, and sends
+// the request again with the credentials.
+func (t *DigestTransport) retry(req *http.Request, body []byte, resp *http.Response) (*http.Response, error) {
+	challenge, err := parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return resp, nil
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	log.Printf("[DEBUG] Digest challenge received: %s", challenge.Realm)
+	t.mu.Lock()
+	t.challenge = challenge
+	t.count = 0
+	t.mu.Unlock()
+	return t.send(req, body, challenge)
+}
+
+func (t *DigestTransport) send(req *http.Request, body []byte, challenge *digestChallenge) (*http.Response, error) {
+	t.mu.Lock()
+	t.count++
+	count := t.count
+	t.mu.Unlock()
+	authorization, err := t.authorization(req, challenge, count)
+	if err != nil {
+		return nil, err
+	}
+	return t.transport().RoundTrip(copyRequest(req, body, authorization))
+}
+
+func (t *DigestTransport) authorization(req *http.Request, c *digestChallenge, count int) (string, error) {
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", t.Username, c.Realm, t.Password))
+	ha2 := md5Hex(fmt.Sprintf("%s:%s", req.Method, uri))
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, t.Username),
+		fmt.Sprintf(`realm="%s"`, c.Realm),
+		fmt.Sprintf(`nonce="%s"`, c.Nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+	if c.Qop == "" {
+		response := md5Hex(fmt.Sprintf("%s:%s:%s", ha1, c.Nonce, ha2))
+		fields = append(fields, fmt.Sprintf(`response="%s"`, response))
+	} else {
+		cnonce, err := newClientNonce()
+		if err != nil {
+			return "", err
+		}
+		nc := fmt.Sprintf("%08x", count)
+		response := md5Hex(fmt.Sprintf("%s:%s:%s:%s:auth:%s", ha1, c.Nonce, nc, cnonce, ha2))
+		fields = append(fields,
+			"qop=auth",
+			fmt.Sprintf("nc=%s", nc),
+			fmt.Sprintf(`cnonce="%s"`, cnonce),
+			fmt.Sprintf(`response="%s"`, response))
+	}
+	if c.Opaque != "" {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, c.Opaque))
+	}
+	if c.Algorithm != "" {
+		fields = append(fields, fmt.Sprintf("algorithm=%s", c.Algorithm))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	if !strings.HasPrefix(header, "Digest ") {
+		return nil, fmt.Errorf("Not a digest challenge: %s", header)
+	}
+	challenge := &digestChallenge{}
+	for _, param := range splitDigestParams(header[len("Digest "):]) {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "realm":
+			challenge.Realm = value
+		case "nonce":
+			challenge.Nonce = value
+		case "opaque":
+			challenge.Opaque = value
+		case "algorithm":
+			challenge.Algorithm = value
+		case "qop":
+			for _, qop := range strings.Split(value, ",") {
+				if strings.TrimSpace(qop) == "auth" {
+					challenge.Qop = "auth"
+				}
+			}
+		}
+	}
+	if challenge.Algorithm != "" && !strings.EqualFold(challenge.Algorithm, "MD5") {
+		return nil, fmt.Errorf("Unsupported digest algorithm: %s", challenge.Algorithm)
+	}
+	return challenge, nil
+}
+
+// splitDigestParams split the challenge parameters on commas which
+// are not quoted
+func splitDigestParams(s string) []string {
+	var params []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				params = append(params, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(params, s[start:])
+}
+
+func copyRequest(req *http.Request, body []byte, authorization string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	return r
+}
+
+func md5Hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+func newClientNonce() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
@@ -262,8 +262,12 @@ func (c *Client) getFreeboxAPIRequest(request string) string {
 }
 
 func makeAPIErrorResponse(e error) (*apiErrorResponse, error) {
+	apiError, ok := e.(*providers.APIError)
+	if !ok {
+		return nil, e
+	}
 	var resp *apiErrorResponse
-	if err := json.Unmarshal([]byte(e.(*providers.APIError).Message), &resp); err != nil {
+	if err := json.Unmarshal([]byte(apiError.Message), &resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
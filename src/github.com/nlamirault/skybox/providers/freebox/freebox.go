@@ -22,6 +22,7 @@ import (
 
 	"github.com/nlamirault/skybox/config"
 	"github.com/nlamirault/skybox/providers"
+	"github.com/nlamirault/skybox/selfstat"
 	"github.com/nlamirault/skybox/version"
 )
 
@@ -110,6 +111,7 @@ func (c *Client) Authenticate() error {
 	if c.Token == "" {
 		_, err := c.authorize()
 		if err != nil {
+			countAPIError(err)
 			return err
 		}
 		log.Printf("[DEBUG] Freebox authentication done")
@@ -117,16 +119,19 @@ func (c *Client) Authenticate() error {
 	}
 	_, err := c.login()
 	if err != nil {
+		countAPIError(err)
 		return err
 	}
 	_, err = c.login()
 	if err != nil {
+		countAPIError(err)
 		return err
 	}
 	log.Printf("[DEBUG] Freebox login done")
 	if c.SessionToken == "" {
 		_, err = c.openSession()
 		if err != nil {
+			countAPIError(err)
 			return err
 		}
 	}
@@ -135,23 +140,34 @@ func (c *Client) Authenticate() error {
 
 }
 
+// Close closes the session opened by Authenticate
+func (c *Client) Close() error {
+	if c.SessionToken == "" {
+		return nil
+	}
+	_, err := c.closeSession()
+	return err
+}
+
 func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
 	log.Printf("[DEBUG] Freebox retrieve statistics\n")
 	resp, err := c.connectionStatus()
 	if err != nil {
-		apiError, err := makeAPIErrorResponse(err)
-		if err != nil {
+		apiError := countAPIError(err)
+		if apiError == nil || apiError.ErrorCode != authRequiredError {
 			return nil, err
 		}
-		if apiError.ErrorCode == authRequiredError {
-			c.SessionToken = ""
-			_, err := c.openSession()
-			if err != nil {
-				return nil, err
-			}
-			c.Statistics()
+		log.Printf("[DEBUG] Freebox session expired")
+		selfstat.Add("skybox_authentications", map[string]string{"box": "freebox"}, "count", 1)
+		c.SessionToken = ""
+		if _, err := c.openSession(); err != nil {
+			countAPIError(err)
+			return nil, err
+		}
+		if resp, err = c.connectionStatus(); err != nil {
+			countAPIError(err)
+			return nil, err
 		}
-		return nil, err
 	}
 	log.Printf("[DEBUG] Freebox connection status received")
 	return &providers.ProviderConnectionStatistics{
@@ -161,5 +177,19 @@ func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
 		BytesUp:       resp.Result.BytesUp,
 		BandwidthDown: resp.Result.BandwidthDown,
 		BandwidthUp:   resp.Result.BandwidthUp,
+		State:         resp.Result.State,
+		IPv4:          resp.Result.IPv4,
 	}, nil
 }
+
+// countAPIError counts the Freebox API error in the internal metrics, by
+// error code. It returns nil if the error isn't an API error.
+func countAPIError(err error) *apiErrorResponse {
+	apiError, decodeErr := makeAPIErrorResponse(err)
+	if decodeErr != nil || apiError == nil {
+		return nil
+	}
+	selfstat.Add("skybox_api_errors",
+		map[string]string{"box": "freebox", "error_code": apiError.ErrorCode}, "count", 1)
+	return apiError
+}
@@ -20,8 +20,10 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/nlamirault/skybox/providers"
+	"github.com/nlamirault/skybox/selfstat"
 )
 
 func newFreebox(handler http.HandlerFunc) (*Client, *httptest.Server, error) {
@@ -97,7 +99,7 @@ func TestFreeboxAPIAuthorize(t *testing.T) {
 	}
 	if resp.Result.AppToken != "dyNYgfK0Ya6FWGqq83sBHa7TwzWo+pg4fDFUJHShcjVYzTfaRrZzm93p7OTAfH/0" ||
 		resp.Result.TrackID != 42 {
-		t.Fatalf("Freebox API authorize response: %s", resp)
+		t.Fatalf("Freebox API authorize response: %v", resp)
 	}
 
 }
@@ -123,7 +125,7 @@ func TestFreeboxAPILogin(t *testing.T) {
 		t.Fatalf("Error API call login: %v", err)
 	}
 	if resp.Result.Challenge != "VzhbtpR4r8CLaJle2QgJBEkyd8JPb0zL" {
-		t.Fatalf("Freebox API login response: %s", resp)
+		t.Fatalf("Freebox API login response: %v", resp)
 	}
 	if fbx.Challenge != "VzhbtpR4r8CLaJle2QgJBEkyd8JPb0zL" {
 		t.Fatalf("Freebox login challenge not set: %v", fbx)
@@ -155,7 +157,7 @@ func TestFreeboxAPIOpenSession(t *testing.T) {
 		t.Fatalf("Error API call open session: %v", err)
 	}
 	if resp.Result.SessionToken != "35JYdQSvkcBYK84IFMU7H86clfhS75OzwlQrKlQN1gBchDd62RGzDpgC7YB9jB2" {
-		t.Fatalf("Freebox API open session response: %s", resp)
+		t.Fatalf("Freebox API open session response: %v", resp)
 	}
 	if fbx.SessionToken != "35JYdQSvkcBYK84IFMU7H86clfhS75OzwlQrKlQN1gBchDd62RGzDpgC7YB9jB2" {
 		t.Fatalf("Freebox session token not set: %v", fbx)
@@ -179,9 +181,97 @@ func TestFreeboxAPICloseSession(t *testing.T) {
 		t.Fatalf("Error API call close session: %v", err)
 	}
 	if !resp.Success {
-		t.Fatalf("Freebox API close session response: %s", resp)
+		t.Fatalf("Freebox API close session response: %v", resp)
 	}
 	if fbx.SessionToken != "" {
 		t.Fatalf("Freebox session token set: %v", fbx)
 	}
 }
+
+func TestFreeboxStatisticsSessionExpired(t *testing.T) {
+	expired := true
+	fbx, server, err := newFreebox(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v3/login/session":
+			expired = false
+			fmt.Fprintln(w, `{"success": true, "result": {"session_token": "new-token"}}`)
+		case expired:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"success": false, "msg": "Vous devez vous connecter", "error_code": "auth_required"}`)
+		default:
+			fmt.Fprintln(w, `{"success": true, "result": {"rate_down": 1024, "rate_up": 512, "state": "up"}}`)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	resp, err := fbx.Statistics()
+	if err != nil {
+		t.Fatalf("Error retrieving statistics: %v", err)
+	}
+	if resp.RateDown != 1024 || resp.RateUp != 512 || fbx.SessionToken != "new-token" {
+		t.Fatalf("Invalid statistics after new session: %v", resp)
+	}
+	points, err := selfstat.Points(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := map[string]interface{}{}
+	for _, pt := range points {
+		counted[pt.Name()+"/"+pt.Tags()["error_code"]] = pt.Fields()["count"]
+	}
+	if counted["skybox_api_errors/auth_required"] != int64(1) || counted["skybox_authentications/"] != int64(1) {
+		t.Fatalf("Invalid internal metrics: %v", counted)
+	}
+}
+
+func TestFreeboxStatisticsNetworkError(t *testing.T) {
+	fbx, server, err := newFreebox(func(w http.ResponseWriter, r *http.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	if _, err := fbx.Statistics(); err == nil {
+		t.Fatalf("No error with the box down")
+	}
+}
+
+func TestFreeboxStatistics(t *testing.T) {
+	fbx, server, err := newFreebox(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{
+  "success": true,
+  "result": {
+         "type": "ethernet",
+         "rate_down": 2048,
+         "bytes_up": 1000,
+         "rate_up": 256,
+         "bandwidth_up": 100000000,
+         "ipv4": "82.64.12.34",
+         "bandwidth_down": 1000000000,
+         "state": "up",
+         "bytes_down": 4000,
+         "media": "ftth"
+    }
+}`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	resp, err := fbx.Statistics()
+	if err != nil {
+		t.Fatalf("Error retrieving statistics: %v", err)
+	}
+	if resp.RateDown != 2048 || resp.RateUp != 256 || resp.BytesDown != 4000 || resp.BytesUp != 1000 {
+		t.Fatalf("Invalid Freebox statistics: %v", resp)
+	}
+	if resp.State != "up" || resp.IPv4 != "82.64.12.34" {
+		t.Fatalf("Invalid Freebox connection state: %v", resp)
+	}
+}
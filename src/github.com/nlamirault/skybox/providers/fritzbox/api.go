@@ -0,0 +1,150 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fritzbox
+
+import (
+	"log"
+
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	defaultURL = "http://fritz.box:49000/"
+
+	// TR-064 device description
+	descriptionURL = "/tr64desc.xml"
+
+	deviceInfoService   = "urn:dslforum-org:service:DeviceInfo:1"
+	deviceInfoControl   = "/upnp/control/deviceinfo"
+	wanCommonService    = "urn:dslforum-org:service:WANCommonInterfaceConfig:1"
+	wanCommonControl    = "/upnp/control/wancommonifconfig1"
+	wanIPConnService    = "urn:dslforum-org:service:WANIPConnection:1"
+	wanIPConnControl    = "/upnp/control/wanipconnection1"
+	connectionConnected = "Connected"
+)
+
+// apiDeviceInfoResponse is returned by the `DeviceInfo:GetInfo` action
+type apiDeviceInfoResponse struct {
+	ModelName       string
+	SoftwareVersion string
+}
+
+func (c *Client) deviceInfo() (*apiDeviceInfoResponse, error) {
+	log.Printf("[DEBUG] FritzboxAPI device info\n")
+	result, err := providers.DoSOAP(c, deviceInfoControl, deviceInfoService, "GetInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiDeviceInfoResponse{
+		ModelName:       result["NewModelName"],
+		SoftwareVersion: result["NewSoftwareVersion"],
+	}
+	log.Printf("[DEBUG] FritzboxAPI device info response: %v", resp)
+	return resp, nil
+}
+
+// apiTotalBytesResponse is returned by the `WANCommonInterfaceConfig:GetTotalBytesSent`
+// and `WANCommonInterfaceConfig:GetTotalBytesReceived` actions
+type apiTotalBytesResponse struct {
+	BytesUp   int
+	BytesDown int
+}
+
+func (c *Client) totalBytes() (*apiTotalBytesResponse, error) {
+	log.Printf("[DEBUG] FritzboxAPI total bytes\n")
+	sent, err := providers.DoSOAP(c, wanCommonControl, wanCommonService, "GetTotalBytesSent", nil)
+	if err != nil {
+		return nil, err
+	}
+	received, err := providers.DoSOAP(c, wanCommonControl, wanCommonService, "GetTotalBytesReceived", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiTotalBytesResponse{}
+	if resp.BytesUp, err = providers.SOAPInt(sent, "NewTotalBytesSent"); err != nil {
+		return nil, err
+	}
+	if resp.BytesDown, err = providers.SOAPInt(received, "NewTotalBytesReceived"); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] FritzboxAPI total bytes response: %v", resp)
+	return resp, nil
+}
+
+// apiLinkPropertiesResponse is returned by the `WANCommonInterfaceConfig:GetCommonLinkProperties` action
+type apiLinkPropertiesResponse struct {
+	// DSL, Ethernet, ...
+	AccessType string
+	// available upload bandwidth in bit/s
+	BandwidthUp int
+	// available download bandwidth in bit/s
+	BandwidthDown int
+	// Up, Down, Initializing or Unavailable
+	LinkStatus string
+}
+
+func (c *Client) linkProperties() (*apiLinkPropertiesResponse, error) {
+	log.Printf("[DEBUG] FritzboxAPI link properties\n")
+	result, err := providers.DoSOAP(c, wanCommonControl, wanCommonService, "GetCommonLinkProperties", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiLinkPropertiesResponse{
+		AccessType: result["NewWANAccessType"],
+		LinkStatus: result["NewPhysicalLinkStatus"],
+	}
+	if resp.BandwidthUp, err = providers.SOAPInt(result, "NewLayer1UpstreamMaxBitRate"); err != nil {
+		return nil, err
+	}
+	if resp.BandwidthDown, err = providers.SOAPInt(result, "NewLayer1DownstreamMaxBitRate"); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] FritzboxAPI link properties response: %v", resp)
+	return resp, nil
+}
+
+// apiConnectionStatusResponse is returned by the `WANIPConnection:GetStatusInfo`
+// and `WANIPConnection:GetExternalIPAddress` actions
+type apiConnectionStatusResponse struct {
+	// Connected, Connecting, Disconnected, ...
+	Status string
+	// connection uptime in seconds
+	Uptime int
+	// public IPv4 address
+	IPv4 string
+}
+
+func (c *Client) connectionStatus() (*apiConnectionStatusResponse, error) {
+	log.Printf("[DEBUG] FritzboxAPI connection status\n")
+	status, err := providers.DoSOAP(c, wanIPConnControl, wanIPConnService, "GetStatusInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiConnectionStatusResponse{
+		Status: status["NewConnectionStatus"],
+	}
+	if resp.Uptime, err = providers.SOAPInt(status, "NewUptime"); err != nil {
+		return nil, err
+	}
+	if resp.Status == connectionConnected {
+		address, err := providers.DoSOAP(c, wanIPConnControl, wanIPConnService, "GetExternalIPAddress", nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.IPv4 = address["NewExternalIPAddress"]
+	}
+	log.Printf("[DEBUG] FritzboxAPI connection status response: %v", resp)
+	return resp, nil
+}
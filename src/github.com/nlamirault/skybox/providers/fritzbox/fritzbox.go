@@ -0,0 +1,146 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fritzbox
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+func init() {
+	providers.Add("fritzbox", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client is the Fritz!Box TR-064 client
+type Client struct {
+	// The client to use when sending requests.
+	Client *http.Client
+	// Endpoint is the base URL for API requests.
+	Endpoint *url.URL
+	Username string
+	Password string
+	// Meter computes the rates from the total bytes counters
+	Meter providers.RateMeter
+}
+
+// New returns a Fritz!Box Client
+func New() *Client {
+	baseURL, _ := url.Parse(defaultURL)
+	return &Client{
+		Client:   &http.Client{},
+		Endpoint: baseURL,
+	}
+}
+
+func (c *Client) Description() string {
+	return "fritzbox"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return c.Endpoint
+}
+
+func (c *Client) GetHTTPClient() *http.Client {
+	return c.Client
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+	request.Header.Add("User-Agent", providers.UserAgent)
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.Fritzbox == nil {
+		return fmt.Errorf("Fritz!Box configuration not found: %v", config)
+	}
+	url, err := url.Parse(config.Fritzbox.URL)
+	if err != nil {
+		return fmt.Errorf("Fritz!Box configuration invalid: %s", err.Error())
+	}
+	c.Endpoint = url
+	c.Username = config.Fritzbox.Username
+	c.Password = config.Fritzbox.Password
+	c.Client.Transport = providers.NewDigestTransport(c.Username, c.Password)
+	return nil
+}
+
+// Ping contact the Fritz!Box, and check the TR-064 description
+func (c *Client) Ping() error {
+	u, err := c.Endpoint.Parse(descriptionURL)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fritz!Box TR-064 not available: %s", resp.Status)
+	}
+	log.Printf("[DEBUG] Fritz!Box Ping received")
+	return nil
+}
+
+// Authenticate checks the credentials using an action which requires them
+func (c *Client) Authenticate() error {
+	resp, err := c.deviceInfo()
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Fritz!Box authentication done: %s %s",
+		resp.ModelName, resp.SoftwareVersion)
+	return nil
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] Fritz!Box retrieve statistics\n")
+	bytes, err := c.totalBytes()
+	if err != nil {
+		return nil, err
+	}
+	link, err := c.linkProperties()
+	if err != nil {
+		return nil, err
+	}
+	status, err := c.connectionStatus()
+	if err != nil {
+		return nil, err
+	}
+	rateUp, rateDown := c.Meter.Update(bytes.BytesUp, bytes.BytesDown, time.Now())
+	log.Printf("[DEBUG] Fritz!Box connection status received")
+	return &providers.ProviderConnectionStatistics{
+		RateDown:      rateDown,
+		RateUp:        rateUp,
+		BytesDown:     bytes.BytesDown,
+		BytesUp:       bytes.BytesUp,
+		BandwidthDown: link.BandwidthDown,
+		BandwidthUp:   link.BandwidthUp,
+		State:         status.Status,
+		IPv4:          status.IPv4,
+		Uptime:        status.Uptime,
+	}, nil
+}
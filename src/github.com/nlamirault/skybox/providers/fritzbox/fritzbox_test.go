@@ -0,0 +1,158 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fritzbox
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nlamirault/skybox/providers"
+)
+
+func newFritzbox(handler http.HandlerFunc) (*Client, *httptest.Server, error) {
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	fritzbox := New()
+	fakeURL, err := url.Parse(server.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	fritzbox.Endpoint = fakeURL
+	return fritzbox, server, nil
+}
+
+func soapResponse(w http.ResponseWriter, action string, service string, args string) {
+	w.Header().Set("Content-Type", providers.SOAPMediaType)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body>
+<u:%sResponse xmlns:u="%s">
+%s
+</u:%sResponse>
+</s:Body>
+</s:Envelope>`, action, service, args, action)
+}
+
+func fakeTR064(w http.ResponseWriter, r *http.Request) {
+	switch r.Header.Get("SOAPAction") {
+	case wanCommonService + "#GetTotalBytesSent":
+		soapResponse(w, "GetTotalBytesSent", wanCommonService,
+			"<NewTotalBytesSent>1000</NewTotalBytesSent>")
+	case wanCommonService + "#GetTotalBytesReceived":
+		soapResponse(w, "GetTotalBytesReceived", wanCommonService,
+			"<NewTotalBytesReceived>5000</NewTotalBytesReceived>")
+	case wanCommonService + "#GetCommonLinkProperties":
+		soapResponse(w, "GetCommonLinkProperties", wanCommonService,
+			`<NewWANAccessType>DSL</NewWANAccessType>
+<NewLayer1UpstreamMaxBitRate>5000000</NewLayer1UpstreamMaxBitRate>
+<NewLayer1DownstreamMaxBitRate>50000000</NewLayer1DownstreamMaxBitRate>
+<NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>`)
+	case wanIPConnService + "#GetStatusInfo":
+		soapResponse(w, "GetStatusInfo", wanIPConnService,
+			`<NewConnectionStatus>Connected</NewConnectionStatus>
+<NewLastConnectionError>ERROR_NONE</NewLastConnectionError>
+<NewUptime>3600</NewUptime>`)
+	case wanIPConnService + "#GetExternalIPAddress":
+		soapResponse(w, "GetExternalIPAddress", wanIPConnService,
+			"<NewExternalIPAddress>203.0.113.1</NewExternalIPAddress>")
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+<s:Body>
+<s:Fault>
+<faultcode>s:Client</faultcode>
+<faultstring>UPnPError</faultstring>
+<detail>
+<UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+<errorCode>401</errorCode>
+<errorDescription>Invalid Action</errorDescription>
+</UPnPError>
+</detail>
+</s:Fault>
+</s:Body>
+</s:Envelope>`)
+	}
+}
+
+func TestFritzboxAPIStatistics(t *testing.T) {
+	fritzbox, server, err := newFritzbox(fakeTR064)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	resp, err := fritzbox.Statistics()
+	if err != nil {
+		t.Fatalf("Error API call statistics: %v", err)
+	}
+	if resp.BytesUp != 1000 || resp.BytesDown != 5000 ||
+		resp.BandwidthUp != 5000000 || resp.BandwidthDown != 50000000 {
+		t.Fatalf("Fritz!Box statistics response: %v", resp)
+	}
+	if resp.State != "Connected" || resp.Uptime != 3600 || resp.IPv4 != "203.0.113.1" {
+		t.Fatalf("Fritz!Box connection status response: %v", resp)
+	}
+}
+
+func TestFritzboxAPIError(t *testing.T) {
+	fritzbox, server, err := newFritzbox(fakeTR064)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	_, err = fritzbox.deviceInfo()
+	if err == nil {
+		t.Fatalf("No error with an invalid action")
+	}
+	soapError, ok := err.(*providers.SOAPError)
+	if !ok {
+		t.Fatalf("Invalid error type: %v", err)
+	}
+	if soapError.Code != 401 || soapError.Description != "Invalid Action" {
+		t.Fatalf("Invalid SOAP fault parsing: %v", soapError)
+	}
+}
+
+func TestFritzboxDigestAuthentication(t *testing.T) {
+	fritzbox, server, err := newFritzbox(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), `Digest username="skybox"`) {
+			w.Header().Set("WWW-Authenticate",
+				`Digest realm="F!Box SOAP-Auth", nonce="6F3BA2E0F2C8D7A5", algorithm=MD5, qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		soapResponse(w, "GetInfo", deviceInfoService,
+			`<NewModelName>FRITZ!Box 7590</NewModelName>
+<NewSoftwareVersion>154.07.12</NewSoftwareVersion>`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	fritzbox.Client.Transport = providers.NewDigestTransport("skybox", "secret")
+
+	resp, err := fritzbox.deviceInfo()
+	if err != nil {
+		t.Fatalf("Error API call device info: %v", err)
+	}
+	if resp.ModelName != "FRITZ!Box 7590" {
+		t.Fatalf("Fritz!Box device info response: %v", resp)
+	}
+}
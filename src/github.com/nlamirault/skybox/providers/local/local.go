@@ -0,0 +1,158 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	defaultProcPath = "/proc"
+	defaultSysPath  = "/sys"
+)
+
+func init() {
+	providers.Add("local", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client reads the counters of a host interface
+type Client struct {
+	// Interface is the WAN interface. The interface of the default route
+	// if empty.
+	Interface string
+	ProcPath  string
+	SysPath   string
+	// Meter computes the rates from the interface counters
+	Meter providers.RateMeter
+}
+
+// New returns a local Client
+func New() *Client {
+	return &Client{
+		ProcPath: defaultProcPath,
+		SysPath:  defaultSysPath,
+	}
+}
+
+func (c *Client) Description() string {
+	return "local"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return &url.URL{Scheme: "file", Path: c.ProcPath}
+}
+
+// GetHTTPClient returns nil, the local provider doesn't use HTTP
+func (c *Client) GetHTTPClient() *http.Client {
+	return nil
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.Local == nil {
+		return fmt.Errorf("Local configuration not found: %v", config)
+	}
+	c.Interface = config.Local.Interface
+	if config.Local.ProcPath != "" {
+		c.ProcPath = config.Local.ProcPath
+	}
+	if config.Local.SysPath != "" {
+		c.SysPath = config.Local.SysPath
+	}
+	return nil
+}
+
+// Ping checks that the interface counters are available
+func (c *Client) Ping() error {
+	if _, err := os.Stat(c.ProcPath); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Local Ping received")
+	return nil
+}
+
+// Authenticate resolves the interface if it isn't configured. The local
+// provider doesn't need any authentication.
+func (c *Client) Authenticate() error {
+	if c.Interface != "" {
+		return nil
+	}
+	iface, err := defaultRouteInterface(c.ProcPath)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Local default route interface: %s", iface)
+	c.Interface = iface
+	return nil
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] Local retrieve statistics\n")
+	if err := c.Authenticate(); err != nil {
+		return nil, err
+	}
+	counters, err := readNetDev(c.ProcPath, c.Interface)
+	if err != nil {
+		return nil, err
+	}
+	stats := &providers.ProviderConnectionStatistics{
+		BytesDown:     counters.RxBytes,
+		BytesUp:       counters.TxBytes,
+		BandwidthDown: interfaceSpeed(c.SysPath, c.Interface),
+	}
+	stats.BandwidthUp = stats.BandwidthDown
+	stats.RateUp, stats.RateDown = c.Meter.Update(stats.BytesUp, stats.BytesDown, time.Now())
+	if state, err := readSysNet(c.SysPath, c.Interface, "operstate"); err == nil {
+		stats.State = state
+	}
+	stats.IPv4 = interfaceIPv4(c.Interface)
+	log.Printf("[DEBUG] Local interface statistics received")
+	return stats, nil
+}
+
+// interfaceIPv4 returns the first IPv4 address of the interface
+func interfaceIPv4(name string) string {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+	}
+	return ""
+}
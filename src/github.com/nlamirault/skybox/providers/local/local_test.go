@@ -0,0 +1,91 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	netDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 11818390    2225    0    0    0     0          0         0 11818390    2225    0    0    0     0       0          0
+  eth1:73519260811 61048457    0    0    0     0          0         0 8261938312 23081371    0    0    0     0       0          0
+`
+	netRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth1	00000000	010200C0	0003	0	0	0	00000000	0	0	0
+`
+)
+
+func newLocal(t *testing.T) (*Client, string) {
+	dir, err := ioutil.TempDir("", "skybox-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"proc/net/dev":                 netDev,
+		"proc/net/route":               netRoute,
+		"sys/class/net/eth1/speed":     "1000\n",
+		"sys/class/net/eth1/operstate": "up\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := New()
+	client.ProcPath = filepath.Join(dir, "proc")
+	client.SysPath = filepath.Join(dir, "sys")
+	return client, dir
+}
+
+func TestLocalDefaultRouteInterface(t *testing.T) {
+	client, dir := newLocal(t)
+	defer os.RemoveAll(dir)
+
+	if err := client.Authenticate(); err != nil {
+		t.Fatalf("Error local authenticate: %v", err)
+	}
+	if client.Interface != "eth1" {
+		t.Fatalf("Invalid default route interface: %s", client.Interface)
+	}
+}
+
+func TestLocalStatistics(t *testing.T) {
+	client, dir := newLocal(t)
+	defer os.RemoveAll(dir)
+
+	resp, err := client.Statistics()
+	if err != nil {
+		t.Fatalf("Error local statistics: %v", err)
+	}
+	if resp.BytesDown != 73519260811 || resp.BytesUp != 8261938312 ||
+		resp.BandwidthDown != 1000000000 || resp.State != "up" {
+		t.Fatalf("Local statistics response: %v", resp)
+	}
+
+	client.Interface = "eth9"
+	if _, err := client.Statistics(); err == nil {
+		t.Fatalf("No error with an unknown interface")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// netDevCounters represents the counters of an interface into /proc/net/dev
+type netDevCounters struct {
+	RxBytes int
+	TxBytes int
+}
+
+// readNetDev returns the counters of an interface from /proc/net/dev
+func readNetDev(procPath, iface string) (*netDevCounters, error) {
+	f, err := os.Open(filepath.Join(procPath, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != iface {
+			continue
+		}
+		// Receive: bytes packets errs drop fifo frame compressed multicast
+		// Transmit: bytes packets ...
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("Invalid /proc/net/dev line for %s", iface)
+		}
+		rx, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := strconv.ParseInt(fields[8], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &netDevCounters{RxBytes: int(rx), TxBytes: int(tx)}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("Interface not found into /proc/net/dev: %s", iface)
+}
+
+// defaultRouteInterface returns the interface of the default route
+// from /proc/net/route
+func defaultRouteInterface(procPath string) (string, error) {
+	f, err := os.Open(filepath.Join(procPath, "net", "route"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		if fields[1] == "00000000" && fields[7] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("No default route into /proc/net/route")
+}
+
+// readSysNet returns the content of an interface attribute into /sys/class/net
+func readSysNet(sysPath, iface, attribute string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(sysPath, "class", "net", iface, attribute))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// interfaceSpeed returns the speed in bit/s of an interface. Virtual
+// interfaces and links down have no speed.
+func interfaceSpeed(sysPath, iface string) int {
+	content, err := readSysNet(sysPath, iface, "speed")
+	if err != nil {
+		return 0
+	}
+	mbits, err := strconv.Atoi(content)
+	if err != nil || mbits < 0 {
+		return 0
+	}
+	return mbits * 1000000
+}
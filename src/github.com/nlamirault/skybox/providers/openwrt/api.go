@@ -0,0 +1,285 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openwrt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/nlamirault/skybox/providers"
+	"github.com/nlamirault/skybox/selfstat"
+)
+
+const (
+	defaultURL = "http://192.168.1.1/"
+
+	ubusPath = "/ubus"
+
+	// Session ID used before login
+	anonymousSession = "00000000000000000000000000000000"
+
+	// loadScale is the fixed point scale of the load averages
+	loadScale = 65536
+
+	// ubus status codes
+	ubusStatusOK               = 0
+	ubusStatusPermissionDenied = 6
+)
+
+// ubusStatusMessages are the messages of the ubus status codes
+var ubusStatusMessages = map[int]string{
+	1: "invalid command",
+	2: "invalid argument",
+	3: "method not found",
+	4: "not found",
+	5: "no data",
+	6: "permission denied",
+	7: "timeout",
+	8: "not supported",
+	9: "unknown error",
+}
+
+// UbusError represents an error from the ubus JSON-RPC API
+type UbusError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *UbusError) Error() string {
+	return fmt.Sprintf("ubus %d / %s", e.Code, e.Message)
+}
+
+type apiRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type apiResponse struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      int               `json:"id"`
+	Result  []json.RawMessage `json:"result"`
+	Error   *UbusError        `json:"error"`
+}
+
+// call perform a ubus call using the JSON-RPC API
+func (c *Client) call(object, method string, args interface{}, result interface{}) error {
+	session := c.SessionID
+	if session == "" {
+		session = anonymousSession
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	c.requestID++
+	var resp apiResponse
+	err := providers.Do(
+		c,
+		"POST",
+		ubusPath,
+		apiRequest{
+			JSONRPC: "2.0",
+			ID:      c.requestID,
+			Method:  "call",
+			Params:  []interface{}{session, object, method, args},
+		},
+		&resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if len(resp.Result) == 0 {
+		return &UbusError{Code: -1, Message: "empty result"}
+	}
+	var status int
+	if err := json.Unmarshal(resp.Result[0], &status); err != nil {
+		return err
+	}
+	if status != ubusStatusOK {
+		return &UbusError{Code: status, Message: ubusStatusMessages[status]}
+	}
+	if result != nil && len(resp.Result) > 1 {
+		return json.Unmarshal(resp.Result[1], result)
+	}
+	return nil
+}
+
+// apiLoginResponse is returned by the `session login` call
+type apiLoginResponse struct {
+	Session string `json:"ubus_rpc_session"`
+	Timeout int    `json:"timeout"`
+	Expires int    `json:"expires"`
+}
+
+func (c *Client) login() (*apiLoginResponse, error) {
+	log.Printf("[DEBUG] OpenWrt login\n")
+	c.SessionID = ""
+	var resp apiLoginResponse
+	err := c.call("session", "login", map[string]string{
+		"username": c.Username,
+		"password": c.Password,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	c.SessionID = resp.Session
+	log.Printf("[DEBUG] OpenWrt login response: %v", resp.Timeout)
+	return &resp, nil
+}
+
+// apiInterfaceStatusResponse is returned by the `network.interface.<name> status` call
+type apiInterfaceStatusResponse struct {
+	Up        bool   `json:"up"`
+	Uptime    int    `json:"uptime"`
+	L3Device  string `json:"l3_device"`
+	Device    string `json:"device"`
+	Proto     string `json:"proto"`
+	IPv4Addrs []struct {
+		Address string `json:"address"`
+		Mask    int    `json:"mask"`
+	} `json:"ipv4-address"`
+}
+
+func (c *Client) interfaceStatus() (*apiInterfaceStatusResponse, error) {
+	log.Printf("[DEBUG] OpenWrt interface status: %s\n", c.Interface)
+	var resp apiInterfaceStatusResponse
+	err := c.callWithLogin(fmt.Sprintf("network.interface.%s", c.Interface), "status", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] OpenWrt interface status response: %v", resp)
+	return &resp, nil
+}
+
+// apiDeviceStatusResponse is returned by the `network.device status` call
+type apiDeviceStatusResponse struct {
+	Up      bool   `json:"up"`
+	Carrier bool   `json:"carrier"`
+	Speed   string `json:"speed"`
+	// Statistics of the device
+	Statistics struct {
+		RxBytes int `json:"rx_bytes"`
+		TxBytes int `json:"tx_bytes"`
+	} `json:"statistics"`
+}
+
+func (c *Client) deviceStatus(device string) (*apiDeviceStatusResponse, error) {
+	log.Printf("[DEBUG] OpenWrt device status: %s\n", device)
+	var resp apiDeviceStatusResponse
+	err := c.callWithLogin("network.device", "status", map[string]string{"name": device}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] OpenWrt device status response: %v", resp)
+	return &resp, nil
+}
+
+// apiClientsResponse is returned by the `hostapd.<iface> get_clients` call
+type apiClientsResponse struct {
+	Clients map[string]struct {
+		Authorized bool `json:"authorized"`
+		Signal     int  `json:"signal"`
+	} `json:"clients"`
+}
+
+// wirelessClients returns the MAC addresses of the clients associated
+// with the wireless interfaces
+func (c *Client) wirelessClients() ([]string, error) {
+	var clients []string
+	for _, iface := range c.Wireless {
+		log.Printf("[DEBUG] OpenWrt wireless clients: %s\n", iface)
+		var resp apiClientsResponse
+		err := c.callWithLogin(fmt.Sprintf("hostapd.%s", iface), "get_clients", nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for mac := range resp.Clients {
+			clients = append(clients, mac)
+		}
+	}
+	sort.Strings(clients)
+	log.Printf("[DEBUG] OpenWrt wireless clients response: %v", clients)
+	return clients, nil
+}
+
+// apiSystemInfoResponse is returned by the `system info` call
+type apiSystemInfoResponse struct {
+	Uptime int   `json:"uptime"`
+	Load   []int `json:"load"`
+	Memory struct {
+		Total int `json:"total"`
+		Free  int `json:"free"`
+	} `json:"memory"`
+}
+
+func (c *Client) systemInfo() (*apiSystemInfoResponse, error) {
+	log.Printf("[DEBUG] OpenWrt system info\n")
+	var resp apiSystemInfoResponse
+	err := c.callWithLogin("system", "info", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] OpenWrt system info response: %v", resp)
+	return &resp, nil
+}
+
+// apiLeasesResponse is returned by the `luci-rpc getDHCPLeases` call
+type apiLeasesResponse struct {
+	Leases []struct {
+		Hostname string `json:"hostname"`
+		MACAddr  string `json:"macaddr"`
+		IPAddr   string `json:"ipaddr"`
+	} `json:"dhcp_leases"`
+}
+
+// dhcpLeases returns the MAC addresses of the hosts with a DHCP lease
+func (c *Client) dhcpLeases() ([]string, error) {
+	log.Printf("[DEBUG] OpenWrt DHCP leases\n")
+	var resp apiLeasesResponse
+	err := c.callWithLogin("luci-rpc", "getDHCPLeases", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]bool{}
+	for _, lease := range resp.Leases {
+		hosts[lease.MACAddr] = true
+	}
+	var leases []string
+	for mac := range hosts {
+		leases = append(leases, mac)
+	}
+	sort.Strings(leases)
+	log.Printf("[DEBUG] OpenWrt DHCP leases response: %v", leases)
+	return leases, nil
+}
+
+// callWithLogin perform a ubus call, and login again if the session expired
+func (c *Client) callWithLogin(object, method string, args interface{}, result interface{}) error {
+	err := c.call(object, method, args, result)
+	if ubusError, ok := err.(*UbusError); ok && ubusError.Code == ubusStatusPermissionDenied {
+		log.Printf("[DEBUG] OpenWrt session expired")
+		selfstat.Add("skybox_authentications", map[string]string{"box": "openwrt"}, "count", 1)
+		if _, err := c.login(); err != nil {
+			return err
+		}
+		return c.call(object, method, args, result)
+	}
+	return err
+}
@@ -0,0 +1,219 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openwrt
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	defaultInterface = "wan"
+
+	stateUp   = "up"
+	stateDown = "down"
+)
+
+func init() {
+	providers.Add("openwrt", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client is the OpenWrt ubus client
+type Client struct {
+	// The client to use when sending requests.
+	Client *http.Client
+	// Endpoint is the base URL for API requests.
+	Endpoint  *url.URL
+	Username  string
+	Password  string
+	SessionID string
+	// Interface is the logical network interface to monitor
+	Interface string
+	// Wireless are the wireless interfaces of the access points
+	Wireless []string
+	// Meter computes the rates from the device statistics
+	Meter providers.RateMeter
+
+	requestID int
+}
+
+// New returns an OpenWrt Client
+func New() *Client {
+	baseURL, _ := url.Parse(defaultURL)
+	return &Client{
+		Client:    &http.Client{},
+		Endpoint:  baseURL,
+		Interface: defaultInterface,
+	}
+}
+
+func (c *Client) Description() string {
+	return "openwrt"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return c.Endpoint
+}
+
+func (c *Client) GetHTTPClient() *http.Client {
+	return c.Client
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+	request.Header.Add("Content-Type", providers.MediaType)
+	request.Header.Add("Accept", providers.AcceptHeader)
+	request.Header.Add("User-Agent", providers.UserAgent)
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.OpenWrt == nil {
+		return fmt.Errorf("OpenWrt configuration not found: %v", config)
+	}
+	url, err := url.Parse(config.OpenWrt.URL)
+	if err != nil {
+		return fmt.Errorf("OpenWrt configuration invalid: %s", err.Error())
+	}
+	c.Endpoint = url
+	c.Username = config.OpenWrt.Username
+	c.Password = config.OpenWrt.Password
+	if config.OpenWrt.Interface != "" {
+		c.Interface = config.OpenWrt.Interface
+	}
+	c.Wireless = config.OpenWrt.Wireless
+	return nil
+}
+
+// Ping contact the ubus endpoint. An access denied reply is enough to
+// know that rpcd answers.
+func (c *Client) Ping() error {
+	err := c.call("session", "access", nil, nil)
+	if _, ok := err.(*UbusError); err != nil && !ok {
+		return err
+	}
+	log.Printf("[DEBUG] OpenWrt Ping received")
+	return nil
+}
+
+func (c *Client) Authenticate() error {
+	if _, err := c.login(); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] OpenWrt login done")
+	return nil
+}
+
+// Close forgets the session, which expires after its timeout
+func (c *Client) Close() error {
+	c.SessionID = ""
+	return nil
+}
+
+// Collectors returns the system and lan collectors, and the wifi one if
+// wireless interfaces are configured
+func (c *Client) Collectors() []string {
+	collectors := []string{"system", "lan"}
+	if len(c.Wireless) > 0 {
+		collectors = append(collectors, "wifi")
+	}
+	return collectors
+}
+
+// Collect retrieves the statistics of the system, lan or wifi collector
+func (c *Client) Collect(name string) (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] OpenWrt retrieve %s statistics\n", name)
+	stats := &providers.ProviderConnectionStatistics{}
+	switch name {
+	case "system":
+		info, err := c.systemInfo()
+		if err != nil {
+			return nil, err
+		}
+		stats.SystemUptime = info.Uptime
+		if len(info.Load) > 0 {
+			stats.Load = float64(info.Load[0]) / loadScale
+		}
+		stats.MemoryTotal = info.Memory.Total
+		stats.MemoryFree = info.Memory.Free
+	case "lan":
+		leases, err := c.dhcpLeases()
+		if err != nil {
+			return nil, err
+		}
+		stats.LANHosts = len(leases)
+	case "wifi":
+		clients, err := c.wirelessClients()
+		if err != nil {
+			return nil, err
+		}
+		stats.WirelessClients = len(clients)
+	default:
+		return nil, fmt.Errorf("OpenWrt unsupported collector: %s", name)
+	}
+	return stats, nil
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] OpenWrt retrieve statistics\n")
+	iface, err := c.interfaceStatus()
+	if err != nil {
+		return nil, err
+	}
+	device := iface.L3Device
+	if device == "" {
+		device = iface.Device
+	}
+	stats := &providers.ProviderConnectionStatistics{
+		State:  stateDown,
+		Uptime: iface.Uptime,
+	}
+	if iface.Up {
+		stats.State = stateUp
+	}
+	if len(iface.IPv4Addrs) > 0 {
+		stats.IPv4 = iface.IPv4Addrs[0].Address
+	}
+	if device != "" {
+		status, err := c.deviceStatus(device)
+		if err != nil {
+			return nil, err
+		}
+		stats.BytesDown = status.Statistics.RxBytes
+		stats.BytesUp = status.Statistics.TxBytes
+		stats.BandwidthDown = parseSpeed(status.Speed)
+		stats.BandwidthUp = stats.BandwidthDown
+	}
+	stats.RateUp, stats.RateDown = c.Meter.Update(stats.BytesUp, stats.BytesDown, time.Now())
+	log.Printf("[DEBUG] OpenWrt connection status received")
+	return stats, nil
+}
+
+// parseSpeed returns the bandwidth in bit/s of a device speed, like 1000F
+func parseSpeed(speed string) int {
+	mbits, err := strconv.Atoi(strings.TrimRight(speed, "FHfh"))
+	if err != nil {
+		return 0
+	}
+	return mbits * 1000000
+}
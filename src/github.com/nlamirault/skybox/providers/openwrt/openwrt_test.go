@@ -0,0 +1,219 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openwrt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nlamirault/skybox/providers"
+)
+
+const sessionID = "c1ed6c7b025d0caca723a816fa61b668"
+
+func newOpenWrt(handler http.HandlerFunc) (*Client, *httptest.Server, error) {
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	openwrt := New()
+	fakeURL, err := url.Parse(server.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	openwrt.Endpoint = fakeURL
+	return openwrt, server, nil
+}
+
+// fakeUbus replies to the ubus calls, and denies access without session
+func fakeUbus(w http.ResponseWriter, r *http.Request) {
+	var request apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", providers.AcceptHeader)
+	object := fmt.Sprintf("%s %s", request.Params[1], request.Params[2])
+	if object == "session login" {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"ubus_rpc_session":"%s","timeout":300,"expires":300}]}`,
+			request.ID, sessionID)
+		return
+	}
+	if request.Params[0] != sessionID {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[6]}`, request.ID)
+		return
+	}
+	switch object {
+	case "network.interface.wan status":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{
+  "up": true, "uptime": 86400, "l3_device": "pppoe-wan", "device": "eth0.2", "proto": "pppoe",
+  "ipv4-address": [{"address": "192.0.2.10", "mask": 32}]}]}`, request.ID)
+	case "network.device status":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{
+  "up": true, "carrier": true, "speed": "1000F",
+  "statistics": {"rx_bytes": 123456789, "tx_bytes": 98765}}]}`, request.ID)
+	case "hostapd.wlan0 get_clients":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"freq": 2437, "clients": {
+  "aa:bb:cc:dd:ee:01": {"authorized": true, "signal": -52},
+  "aa:bb:cc:dd:ee:02": {"authorized": true, "signal": -70}}}]}`, request.ID)
+	case "system info":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{
+  "uptime": 172800, "load": [32768, 16384, 8192],
+  "memory": {"total": 128000000, "free": 64000000, "shared": 100000, "buffered": 2000000}}]}`, request.ID)
+	case "luci-rpc getDHCPLeases":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"dhcp_leases": [
+  {"hostname": "laptop", "macaddr": "aa:bb:cc:dd:ee:01", "ipaddr": "192.168.1.10"},
+  {"hostname": "laptop", "macaddr": "aa:bb:cc:dd:ee:01", "ipaddr": "192.168.1.11"},
+  {"hostname": "phone", "macaddr": "aa:bb:cc:dd:ee:02", "ipaddr": "192.168.1.12"},
+  {"macaddr": "aa:bb:cc:dd:ee:03", "ipaddr": "192.168.1.13"}]}]}`, request.ID)
+	default:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[4]}`, request.ID)
+	}
+}
+
+func TestOpenWrtPing(t *testing.T) {
+	openwrt, server, err := newOpenWrt(fakeUbus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if err := openwrt.Ping(); err != nil {
+		t.Fatalf("Error OpenWrt ping: %v", err)
+	}
+}
+
+func TestOpenWrtLogin(t *testing.T) {
+	openwrt, server, err := newOpenWrt(fakeUbus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	resp, err := openwrt.login()
+	if err != nil {
+		t.Fatalf("Error API call login: %v", err)
+	}
+	if resp.Session != sessionID || openwrt.SessionID != sessionID {
+		t.Fatalf("OpenWrt login response: %v", resp)
+	}
+}
+
+func TestOpenWrtStatistics(t *testing.T) {
+	openwrt, server, err := newOpenWrt(fakeUbus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	openwrt.Wireless = []string{"wlan0"}
+
+	// No session: the provider must login again
+	resp, err := openwrt.Statistics()
+	if err != nil {
+		t.Fatalf("Error OpenWrt statistics: %v", err)
+	}
+	if resp.State != stateUp || resp.Uptime != 86400 || resp.IPv4 != "192.0.2.10" {
+		t.Fatalf("OpenWrt interface status: %v", resp)
+	}
+	if resp.BytesDown != 123456789 || resp.BytesUp != 98765 ||
+		resp.BandwidthDown != 1000000000 {
+		t.Fatalf("OpenWrt device status: %v", resp)
+	}
+	// The wireless clients are only retrieved by the wifi collector
+	if resp.WirelessClients != 0 {
+		t.Fatalf("OpenWrt wireless clients: %v", resp)
+	}
+}
+
+func TestOpenWrtCollectors(t *testing.T) {
+	openwrt := &Client{}
+	if collectors := openwrt.Collectors(); len(collectors) != 2 ||
+		collectors[0] != "system" || collectors[1] != "lan" {
+		t.Fatalf("Invalid collectors without wireless interfaces: %v", collectors)
+	}
+	openwrt.Wireless = []string{"wlan0"}
+	if collectors := openwrt.Collectors(); len(collectors) != 3 || collectors[2] != "wifi" {
+		t.Fatalf("Invalid collectors: %v", collectors)
+	}
+}
+
+func TestOpenWrtCollect(t *testing.T) {
+	var calls []string
+	openwrt, server, err := newOpenWrt(func(w http.ResponseWriter, r *http.Request) {
+		var request apiRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		calls = append(calls, fmt.Sprintf("%s %s", request.Params[1], request.Params[2]))
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fakeUbus(w, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	openwrt.Wireless = []string{"wlan0"}
+	if err := openwrt.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := openwrt.Collect("system")
+	if err != nil {
+		t.Fatalf("Error OpenWrt system collector: %v", err)
+	}
+	if resp.SystemUptime != 172800 || resp.Load != 0.5 ||
+		resp.MemoryTotal != 128000000 || resp.MemoryFree != 64000000 {
+		t.Fatalf("OpenWrt system info: %v", resp)
+	}
+	resp, err = openwrt.Collect("lan")
+	if err != nil {
+		t.Fatalf("Error OpenWrt lan collector: %v", err)
+	}
+	if resp.LANHosts != 3 {
+		t.Fatalf("OpenWrt DHCP leases: %v", resp)
+	}
+	resp, err = openwrt.Collect("wifi")
+	if err != nil {
+		t.Fatalf("Error OpenWrt wifi collector: %v", err)
+	}
+	if resp.WirelessClients != 2 {
+		t.Fatalf("OpenWrt wireless clients: %v", resp)
+	}
+	// Each collector only polls its own statistics
+	if len(calls) != 4 || calls[1] != "system info" ||
+		calls[2] != "luci-rpc getDHCPLeases" || calls[3] != "hostapd.wlan0 get_clients" {
+		t.Fatalf("Invalid calls: %v", calls)
+	}
+	if _, err := openwrt.Collect("dsl"); err == nil {
+		t.Fatalf("No error with an unknown collector")
+	}
+}
+
+func TestOpenWrtUbusError(t *testing.T) {
+	openwrt, server, err := newOpenWrt(fakeUbus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	openwrt.Interface = "lan6"
+
+	_, err = openwrt.Statistics()
+	ubusError, ok := err.(*UbusError)
+	if !ok || ubusError.Code != 4 {
+		t.Fatalf("Invalid ubus error: %v", err)
+	}
+}
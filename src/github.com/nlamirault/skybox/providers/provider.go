@@ -55,6 +55,21 @@ type Provider interface {
 
 	// Statistics perform a call to retrieve box provider statistics
 	Statistics() (*ProviderConnectionStatistics, error)
+
+	// Close closes the session with the box provider
+	Close() error
+}
+
+// CollectorProvider is implemented by the providers which retrieve the
+// statistics of other collectors than the connection one
+type CollectorProvider interface {
+
+	// Collectors returns the names of the other supported collectors
+	Collectors() []string
+
+	// Collect perform the calls to retrieve the statistics of one of
+	// these collectors only
+	Collect(name string) (*ProviderConnectionStatistics, error)
 }
 
 type ProviderConnectionStatistics struct {
@@ -70,4 +85,21 @@ type ProviderConnectionStatistics struct {
 	BandwidthUp int `json:"bandwidth_up"`
 	// available download bandwidth in bit/s
 	BandwidthDown int `json:"bandwidth_down"`
+	// State of the connection
+	State string `json:"state"`
+	// public IPv4 address
+	IPv4 string `json:"ipv4"`
+	// connection uptime in seconds
+	Uptime int `json:"uptime"`
+	// number of clients associated with the wireless access points
+	WirelessClients int `json:"wireless_clients"`
+	// box uptime in seconds
+	SystemUptime int `json:"system_uptime"`
+	// system load average over 1 minute
+	Load float64 `json:"load"`
+	// total and free memory in bytes
+	MemoryTotal int `json:"memory_total"`
+	MemoryFree  int `json:"memory_free"`
+	// number of hosts with a lease on the local network
+	LANHosts int `json:"lan_hosts"`
 }
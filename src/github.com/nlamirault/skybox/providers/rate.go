@@ -0,0 +1,48 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"time"
+)
+
+// RateMeter computes rates in byte/s from total bytes counters, for
+// boxes which doesn't provide the current rates.
+type RateMeter struct {
+	bytesUp   int
+	bytesDown int
+	last      time.Time
+}
+
+// Update records the counters, and returns the rates since the previous
+// call. Rates are zero on the first call, and when a counter is reset.
+func (m *RateMeter) Update(bytesUp, bytesDown int, now time.Time) (int, int) {
+	var rateUp, rateDown int
+	if !m.last.IsZero() {
+		elapsed := now.Sub(m.last).Seconds()
+		if elapsed > 0 {
+			if bytesUp >= m.bytesUp {
+				rateUp = int(float64(bytesUp-m.bytesUp) / elapsed)
+			}
+			if bytesDown >= m.bytesDown {
+				rateDown = int(float64(bytesDown-m.bytesDown) / elapsed)
+			}
+		}
+	}
+	m.bytesUp = bytesUp
+	m.bytesDown = bytesDown
+	m.last = now
+	return rateUp, rateDown
+}
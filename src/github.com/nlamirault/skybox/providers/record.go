@@ -0,0 +1,223 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+)
+
+// Exchange represents an HTTP exchange with a box. Request bodies and
+// headers are not recorded, they could contain credentials: only the
+// JSON-RPC call is.
+type Exchange struct {
+	Provider string `json:"provider"`
+	Method   string `json:"method"`
+	// URL is the request URI, without scheme and host
+	URL string `json:"url"`
+	// Action is the SOAPAction header of SOAP requests
+	Action string `json:"action,omitempty"`
+	// Call is the call of JSON-RPC requests
+	Call       string      `json:"call,omitempty"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// Key identifies the exchanges which are replayed in sequence
+func (e *Exchange) Key() string {
+	return e.Method + " " + e.URL + " " + e.Action + " " + e.Call
+}
+
+// jsonRPCRequest is the part of a JSON-RPC request which identifies the call
+type jsonRPCRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// ExchangeCall returns the call of a JSON-RPC request, or an empty string.
+// The parameters aren't included, except the object and the method of the
+// ubus calls, like "call network.interface.wan status".
+func ExchangeCall(req *http.Request) string {
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return ""
+		}
+		body, err = ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return ""
+		}
+	} else if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			return ""
+		}
+		body = data
+	}
+	var request jsonRPCRequest
+	if err := json.Unmarshal(body, &request); err != nil || request.Method == "" {
+		return ""
+	}
+	call := request.Method
+	if call == "call" && len(request.Params) >= 3 {
+		object, ok1 := request.Params[1].(string)
+		method, ok2 := request.Params[2].(string)
+		if ok1 && ok2 {
+			call += " " + object + " " + method
+		}
+	}
+	return call
+}
+
+// ExchangeURL returns the request URI of an exchange. The path is cleaned,
+// as the box endpoints could be configured with or without trailing slash.
+func ExchangeURL(u *url.URL) string {
+	uri := *u
+	uri.Path = path.Clean("/" + u.Path)
+	uri.RawPath = ""
+	return uri.RequestURI()
+}
+
+// Recorder records the HTTP exchanges of box providers into a fixture
+// file, one JSON exchange per line
+type Recorder struct {
+	mutex    sync.Mutex
+	filename string
+	file     *os.File
+	encoder  *json.Encoder
+}
+
+// NewRecorder opens the fixture file. The exchanges are appended to the
+// recorded ones.
+func NewRecorder(filename string) (*Recorder, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Record HTTP exchanges into %s", filename)
+	return &Recorder{
+		filename: filename,
+		file:     f,
+		encoder:  json.NewEncoder(f),
+	}, nil
+}
+
+// Record wraps the transport of the provider HTTP client, so every
+// exchange with the box is recorded. It must be called after the
+// provider setup, which could replace its HTTP client.
+func (r *Recorder) Record(provider Provider) error {
+	client := provider.GetHTTPClient()
+	if client == nil {
+		return fmt.Errorf("Record box provider doesn't use HTTP: %s", provider.Description())
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &recordTransport{
+		recorder:  r,
+		provider:  provider.Description(),
+		transport: transport,
+	}
+	return nil
+}
+
+// Close closes the fixture file
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.encoder = nil
+	return err
+}
+
+func (r *Recorder) record(exchange *Exchange) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.encoder == nil {
+		return
+	}
+	if err := r.encoder.Encode(exchange); err != nil {
+		log.Printf("[WARN] Can't record HTTP exchange into %s: %s", r.filename, err.Error())
+	}
+}
+
+// recordTransport is an http.RoundTripper which records the exchanges
+type recordTransport struct {
+	recorder  *Recorder
+	provider  string
+	transport http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	t.recorder.record(&Exchange{
+		Provider:   t.provider,
+		Method:     req.Method,
+		URL:        ExchangeURL(req.URL),
+		Action:     req.Header.Get("SOAPAction"),
+		Call:       ExchangeCall(req),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+	})
+	return resp, nil
+}
+
+// LoadExchanges reads the exchanges of a fixture file
+func LoadExchanges(filename string) ([]*Exchange, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var exchanges []*Exchange
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for decoder.More() {
+		var exchange Exchange
+		if err := decoder.Decode(&exchange); err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, &exchange)
+	}
+	return exchanges, nil
+}
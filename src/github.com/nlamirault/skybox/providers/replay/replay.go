@@ -0,0 +1,189 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+func init() {
+	providers.Add("replay", func() providers.Provider {
+		return New()
+	})
+}
+
+// Transport is an http.RoundTripper which serves recorded exchanges.
+// Exchanges with the same request are served in sequence, and the request
+// fails once they are exhausted.
+type Transport struct {
+	mu        sync.Mutex
+	exchanges map[string][]*providers.Exchange
+	cursors   map[string]int
+}
+
+// NewTransport returns a Transport serving these exchanges
+func NewTransport(exchanges []*providers.Exchange) *Transport {
+	t := &Transport{
+		exchanges: map[string][]*providers.Exchange{},
+		cursors:   map[string]int{},
+	}
+	for _, exchange := range exchanges {
+		key := exchange.Key()
+		t.exchanges[key] = append(t.exchanges[key], exchange)
+	}
+	return t
+}
+
+// RoundTrip returns the recorded response of the request
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := (&providers.Exchange{
+		Method: req.Method,
+		URL:    providers.ExchangeURL(req.URL),
+		Action: req.Header.Get("SOAPAction"),
+		Call:   providers.ExchangeCall(req),
+	}).Key()
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	exchanges, ok := t.exchanges[key]
+	if !ok {
+		return nil, fmt.Errorf("No recorded exchange for %s", key)
+	}
+	cursor := t.cursors[key]
+	if cursor >= len(exchanges) {
+		return nil, fmt.Errorf("No more recorded exchanges for %s: %d replayed", key, len(exchanges))
+	}
+	t.cursors[key] = cursor + 1
+	exchange := exchanges[cursor]
+	log.Printf("[DEBUG] Replay exchange %s (%d/%d)", key, cursor+1, len(exchanges))
+	header := http.Header{}
+	for k, v := range exchange.Header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Client replays the exchanges recorded with another box provider
+type Client struct {
+	providers.Provider
+	// Fixture is the file of the recorded exchanges
+	Fixture string
+}
+
+// New returns a replay Client
+func New() *Client {
+	return &Client{}
+}
+
+func (c *Client) Description() string {
+	if c.Provider == nil {
+		return "replay"
+	}
+	return fmt.Sprintf("replay (%s)", c.Provider.Description())
+}
+
+// Close doesn't close the session of the recorded provider, as there is
+// no box
+func (c *Client) Close() error {
+	return nil
+}
+
+// Collectors returns the other collectors of the recorded provider
+func (c *Client) Collectors() []string {
+	if p, ok := c.Provider.(providers.CollectorProvider); ok {
+		return p.Collectors()
+	}
+	return nil
+}
+
+// Collect replays the statistics of a collector of the recorded provider
+func (c *Client) Collect(name string) (*providers.ProviderConnectionStatistics, error) {
+	p, ok := c.Provider.(providers.CollectorProvider)
+	if !ok {
+		return nil, fmt.Errorf("Replay unsupported collector: %s", name)
+	}
+	return p.Collect(name)
+}
+
+// EndPoint returns the endpoint of the recorded provider
+func (c *Client) EndPoint() *url.URL {
+	if c.Provider == nil {
+		return &url.URL{Scheme: "file", Path: c.Fixture}
+	}
+	return c.Provider.EndPoint()
+}
+
+// Setup loads the fixture, and setup the recorded provider to use the
+// recorded exchanges instead of the box
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.Replay == nil || config.Replay.File == "" {
+		return fmt.Errorf("Replay configuration not found: %v", config)
+	}
+	exchanges, err := providers.LoadExchanges(config.Replay.File)
+	if err != nil {
+		return fmt.Errorf("Replay invalid fixture: %s", err.Error())
+	}
+	if len(exchanges) == 0 {
+		return fmt.Errorf("Replay fixture without exchanges: %s", config.Replay.File)
+	}
+	name := config.Replay.Provider
+	if name == "" {
+		name = exchanges[0].Provider
+	}
+	creator := providers.Providers[name]
+	if creator == nil || name == "replay" {
+		return fmt.Errorf("Replay invalid box provider: %s", name)
+	}
+	var recorded []*providers.Exchange
+	for _, exchange := range exchanges {
+		if exchange.Provider == name {
+			recorded = append(recorded, exchange)
+		}
+	}
+	provider := creator()
+	if err := provider.Setup(config); err != nil {
+		return err
+	}
+	client := provider.GetHTTPClient()
+	if client == nil {
+		return fmt.Errorf("Replay box provider doesn't use HTTP: %s", name)
+	}
+	client.Transport = NewTransport(recorded)
+	c.Provider = provider
+	c.Fixture = config.Replay.File
+	log.Printf("[DEBUG] Replay %d exchanges of %s", len(recorded), name)
+	return nil
+}
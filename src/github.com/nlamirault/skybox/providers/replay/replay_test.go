@@ -0,0 +1,334 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+	"github.com/nlamirault/skybox/providers/freebox"
+	"github.com/nlamirault/skybox/providers/openwrt"
+	"github.com/nlamirault/skybox/providers/upnp"
+)
+
+var rateDown = 1000
+
+func fakeFreebox(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", providers.AcceptHeader)
+	switch r.URL.Path {
+	case "/api_version":
+		fmt.Fprintln(w, `{"uid":"23b86ec8091013d668829fe12791fdab","device_name":"Freebox Server","api_version":"3.0","api_base_url":"/api/","device_type":"FreeboxServer1,1"}`)
+	case "/api/v3/connection":
+		fmt.Fprintf(w, `{"success":true,"result":{"type":"ethernet","rate_down":%d,"rate_up":200,"bytes_down":5000,"bytes_up":700,"bandwidth_down":1000000000,"bandwidth_up":200000000,"ipv4":"203.0.113.2","state":"up","media":"ftth"}}`, rateDown)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func record(t *testing.T, fixture string) {
+	server := httptest.NewServer(http.HandlerFunc(fakeFreebox))
+	defer server.Close()
+	conf := config.New()
+	conf.Freebox.URL = server.URL
+
+	recorder, err := providers.NewRecorder(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	fbx := freebox.New()
+	if err := fbx.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Record(fbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fbx.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rate := range []int{1000, 2000} {
+		rateDown = rate
+		if _, err := fbx.Statistics(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "skybox-fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	record(t, f.Name())
+
+	exchanges, err := providers.LoadExchanges(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading fixture: %v", err)
+	}
+	if len(exchanges) != 3 || exchanges[0].Provider != "freebox" ||
+		exchanges[1].URL != "/api/v3/connection" {
+		t.Fatalf("Invalid recorded exchanges: %v", exchanges)
+	}
+
+	conf := config.New()
+	conf.Freebox.URL = "http://127.0.0.1:1/"
+	conf.Replay = &config.ReplayConfiguration{File: f.Name()}
+	replay := New()
+	if err := replay.Setup(conf); err != nil {
+		t.Fatalf("Error replay setup: %v", err)
+	}
+	if err := replay.Ping(); err != nil {
+		t.Fatalf("Error replay ping: %v", err)
+	}
+	for _, rate := range []int{1000, 2000} {
+		resp, err := replay.Statistics()
+		if err != nil {
+			t.Fatalf("Error replay statistics: %v", err)
+		}
+		if resp.RateDown != rate || resp.IPv4 != "203.0.113.2" {
+			t.Fatalf("Invalid replayed statistics: %v", resp)
+		}
+	}
+	// The recorded exchanges are exhausted
+	if _, err := replay.Statistics(); err == nil {
+		t.Fatalf("No error once the exchanges are replayed")
+	}
+}
+
+func TestReplayUnknownExchange(t *testing.T) {
+	transport := NewTransport([]*providers.Exchange{})
+	req, _ := http.NewRequest("GET", "http://mafreebox.free.fr/api_version", nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatalf("No error with an unknown exchange")
+	}
+}
+
+const (
+	wanCommonService     = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+	wanConnectionService = "urn:schemas-upnp-org:service:WANIPConnection:1"
+)
+
+func soapResponse(w http.ResponseWriter, action string, service string, args string) {
+	w.Header().Set("Content-Type", providers.SOAPMediaType)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:%sResponse xmlns:u="%s">%s</u:%sResponse>
+</s:Body></s:Envelope>`, action, service, args, action)
+}
+
+func fakeIGD(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rootDesc.xml" {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+  <modelName>MiniUPnPd</modelName>
+  <serviceList>
+    <service><serviceType>%s</serviceType><controlURL>/ctl/CmnIfCfg</controlURL></service>
+    <service><serviceType>%s</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+  </serviceList>
+</device>
+</root>`, wanCommonService, wanConnectionService)
+		return
+	}
+	switch r.Header.Get("SOAPAction") {
+	case wanCommonService + "#GetTotalBytesSent":
+		soapResponse(w, "GetTotalBytesSent", wanCommonService, "<NewTotalBytesSent>700</NewTotalBytesSent>")
+	case wanCommonService + "#GetTotalBytesReceived":
+		soapResponse(w, "GetTotalBytesReceived", wanCommonService, "<NewTotalBytesReceived>5000</NewTotalBytesReceived>")
+	case wanCommonService + "#GetCommonLinkProperties":
+		soapResponse(w, "GetCommonLinkProperties", wanCommonService,
+			"<NewLayer1UpstreamMaxBitRate>1000000</NewLayer1UpstreamMaxBitRate>"+
+				"<NewLayer1DownstreamMaxBitRate>10000000</NewLayer1DownstreamMaxBitRate>")
+	case wanConnectionService + "#GetStatusInfo":
+		soapResponse(w, "GetStatusInfo", wanConnectionService,
+			"<NewConnectionStatus>Connected</NewConnectionStatus><NewUptime>120</NewUptime>")
+	case wanConnectionService + "#GetExternalIPAddress":
+		soapResponse(w, "GetExternalIPAddress", wanConnectionService,
+			"<NewExternalIPAddress>198.51.100.7</NewExternalIPAddress>")
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestRecordAndReplayUPnP(t *testing.T) {
+	f, err := ioutil.TempFile("", "skybox-fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	server := httptest.NewServer(http.HandlerFunc(fakeIGD))
+	conf := config.New()
+	conf.UPnP.URL = server.URL + "/rootDesc.xml"
+	recorder, err := providers.NewRecorder(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	igd := upnp.New()
+	if err := igd.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Record(igd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := igd.Statistics(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+	server.Close()
+
+	exchanges, err := providers.LoadExchanges(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading fixture: %v", err)
+	}
+	if len(exchanges) == 0 || exchanges[0].URL != "/rootDesc.xml" {
+		t.Fatalf("Device description not recorded: %v", exchanges)
+	}
+
+	conf = config.New()
+	conf.UPnP.URL = "http://127.0.0.1:1/rootDesc.xml"
+	conf.Replay = &config.ReplayConfiguration{File: f.Name()}
+	replay := New()
+	if err := replay.Setup(conf); err != nil {
+		t.Fatalf("Error replay setup: %v", err)
+	}
+	resp, err := replay.Statistics()
+	if err != nil {
+		t.Fatalf("Error replay statistics: %v", err)
+	}
+	if resp.BytesDown != 5000 || resp.BytesUp != 700 || resp.State != "Connected" ||
+		resp.IPv4 != "198.51.100.7" {
+		t.Fatalf("Invalid replayed statistics: %v", resp)
+	}
+}
+
+const ubusSession = "c1ed6c7b025d0caca723a816fa61b668"
+
+// fakeUbus replies to the ubus JSON-RPC calls, which are all sent to /ubus
+func fakeUbus(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID     int           `json:"id"`
+		Params []interface{} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Params) < 3 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", providers.AcceptHeader)
+	call := fmt.Sprintf("%s %s", request.Params[1], request.Params[2])
+	if call == "session login" {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"ubus_rpc_session":"%s","timeout":300}]}`,
+			request.ID, ubusSession)
+		return
+	}
+	if request.Params[0] != ubusSession {
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[6]}`, request.ID)
+		return
+	}
+	switch call {
+	case "network.interface.wan status":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"up":true,"uptime":3600,"l3_device":"eth1",`+
+			`"ipv4-address":[{"address":"192.0.2.10","mask":24}]}]}`, request.ID)
+	case "network.device status":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"up":true,"speed":"1000F",`+
+			`"statistics":{"rx_bytes":5000,"tx_bytes":700}}]}`, request.ID)
+	case "system info":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[0,{"uptime":7200,"load":[65536,0,0],`+
+			`"memory":{"total":128000000,"free":64000000}}]}`, request.ID)
+	default:
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[4]}`, request.ID)
+	}
+}
+
+func TestRecordAndReplayOpenWrt(t *testing.T) {
+	f, err := ioutil.TempFile("", "skybox-fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	server := httptest.NewServer(http.HandlerFunc(fakeUbus))
+	conf := config.New()
+	conf.OpenWrt.URL = server.URL
+	recorder, err := providers.NewRecorder(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := openwrt.New()
+	if err := router.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Record(router); err != nil {
+		t.Fatal(err)
+	}
+	// Without session, the first call is denied and the router logs in
+	if _, err := router.Statistics(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.Collect("system"); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+	server.Close()
+
+	exchanges, err := providers.LoadExchanges(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading fixture: %v", err)
+	}
+	var calls []string
+	for _, exchange := range exchanges {
+		calls = append(calls, exchange.Call)
+	}
+	if len(calls) != 5 || calls[0] != "call network.interface.wan status" ||
+		calls[1] != "call session login" || calls[3] != "call network.device status" ||
+		calls[4] != "call system info" {
+		t.Fatalf("Invalid recorded calls: %v", calls)
+	}
+
+	conf = config.New()
+	conf.OpenWrt.URL = "http://127.0.0.1:1"
+	conf.Replay = &config.ReplayConfiguration{File: f.Name()}
+	replay := New()
+	if err := replay.Setup(conf); err != nil {
+		t.Fatalf("Error replay setup: %v", err)
+	}
+	resp, err := replay.Statistics()
+	if err != nil {
+		t.Fatalf("Error replay statistics: %v", err)
+	}
+	if resp.State != "up" || resp.Uptime != 3600 || resp.IPv4 != "192.0.2.10" ||
+		resp.BytesDown != 5000 || resp.BytesUp != 700 {
+		t.Fatalf("Invalid replayed statistics: %v", resp)
+	}
+	// The collectors of the recorded provider are replayed too
+	resp, err = replay.Collect("system")
+	if err != nil {
+		t.Fatalf("Error replay system collector: %v", err)
+	}
+	if resp.SystemUptime != 7200 || resp.Load != 1 || resp.MemoryFree != 64000000 {
+		t.Fatalf("Invalid replayed system statistics: %v", resp)
+	}
+}
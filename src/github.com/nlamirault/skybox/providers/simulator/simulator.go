@@ -0,0 +1,194 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulator
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	stateUp   = "up"
+	stateDown = "down"
+
+	simulatedIPv4 = "192.0.2.1"
+)
+
+func init() {
+	providers.Add("simulator", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client simulates a box, without any network access
+type Client struct {
+	Config *config.SimulatorConfiguration
+	// Now returns the current time of the simulation
+	Now func() time.Time
+
+	random      *rand.Rand
+	last        time.Time
+	connected   time.Time
+	outageUntil time.Time
+	bytesUp     float64
+	bytesDown   float64
+}
+
+// New returns a simulator Client
+func New() *Client {
+	return &Client{
+		Config: config.New().Simulator,
+		Now:    time.Now,
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (c *Client) Description() string {
+	return "simulator"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return &url.URL{Scheme: "simulator"}
+}
+
+// GetHTTPClient returns nil, the simulator doesn't use HTTP
+func (c *Client) GetHTTPClient() *http.Client {
+	return nil
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.Simulator == nil {
+		return fmt.Errorf("Simulator configuration not found: %v", config)
+	}
+	if config.Simulator.BandwidthDown <= 0 || config.Simulator.BandwidthUp <= 0 {
+		return fmt.Errorf("Simulator configuration invalid bandwidth: %d / %d",
+			config.Simulator.BandwidthUp, config.Simulator.BandwidthDown)
+	}
+	c.Config = config.Simulator
+	seed := config.Simulator.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	c.random = rand.New(rand.NewSource(seed))
+	return nil
+}
+
+func (c *Client) Ping() error {
+	log.Printf("[DEBUG] Simulator Ping received")
+	return nil
+}
+
+func (c *Client) Authenticate() error {
+	log.Printf("[DEBUG] Simulator authentication done")
+	return nil
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] Simulator retrieve statistics\n")
+	now := c.Now()
+	if c.last.IsZero() {
+		c.last = now
+		c.connected = now
+	}
+	elapsed := now.Sub(c.last).Seconds()
+	c.last = now
+
+	if now.Before(c.outageUntil) {
+		return c.statistics(now, 0, 0, stateDown), nil
+	}
+	if !c.outageUntil.IsZero() {
+		// The connection is back: counters start again
+		c.outageUntil = time.Time{}
+		c.reset(now)
+	}
+	if c.happens(c.Config.OutageProbability) {
+		duration := time.Second * time.Duration(c.Config.OutageDuration)
+		log.Printf("[DEBUG] Simulator outage during %s", duration)
+		c.outageUntil = now.Add(duration)
+		return c.statistics(now, 0, 0, stateDown), nil
+	}
+	if c.happens(c.Config.ResetProbability) {
+		log.Printf("[DEBUG] Simulator counters reset")
+		c.reset(now)
+		// The reset happened during the last interval
+		elapsed *= c.random.Float64()
+	}
+
+	load := c.load(now)
+	rateDown := load * float64(c.Config.BandwidthDown) / 8
+	rateUp := load * float64(c.Config.BandwidthUp) / 8 * c.Config.UploadRatio
+	if rateUp > float64(c.Config.BandwidthUp)/8 {
+		rateUp = float64(c.Config.BandwidthUp) / 8
+	}
+	c.bytesDown += rateDown * elapsed
+	c.bytesUp += rateUp * elapsed
+	return c.statistics(now, int(rateUp), int(rateDown), stateUp), nil
+}
+
+func (c *Client) statistics(now time.Time, rateUp, rateDown int, state string) *providers.ProviderConnectionStatistics {
+	stats := &providers.ProviderConnectionStatistics{
+		RateUp:    rateUp,
+		RateDown:  rateDown,
+		BytesUp:   int(c.bytesUp),
+		BytesDown: int(c.bytesDown),
+		State:     state,
+	}
+	if state == stateUp {
+		stats.BandwidthUp = c.Config.BandwidthUp
+		stats.BandwidthDown = c.Config.BandwidthDown
+		stats.IPv4 = simulatedIPv4
+		stats.Uptime = int(now.Sub(c.connected).Seconds())
+	}
+	return stats
+}
+
+func (c *Client) reset(now time.Time) {
+	c.bytesUp = 0
+	c.bytesDown = 0
+	c.connected = now
+}
+
+// load returns the link usage between 0 and 1: a diurnal curve which
+// peaks at PeakHour, with noise and bursts
+func (c *Client) load(now time.Time) float64 {
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+	diurnal := (1 + math.Cos(2*math.Pi*(hour-float64(c.Config.PeakHour))/24)) / 2
+	load := c.Config.BaseLoad + (c.Config.PeakLoad-c.Config.BaseLoad)*diurnal
+	load *= 1 + c.Config.Noise*(2*c.random.Float64()-1)
+	if c.happens(c.Config.BurstProbability) {
+		log.Printf("[DEBUG] Simulator burst")
+		load *= c.Config.BurstFactor
+	}
+	return math.Max(0, math.Min(1, load))
+}
+
+func (c *Client) happens(probability float64) bool {
+	return probability > 0 && c.random.Float64() < probability
+}
@@ -0,0 +1,115 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+)
+
+func newSimulator(t *testing.T, setup func(*config.SimulatorConfiguration)) (*Client, *time.Time) {
+	conf := config.New()
+	conf.Simulator.Seed = 42
+	conf.Simulator.BurstProbability = 0
+	conf.Simulator.OutageProbability = 0
+	conf.Simulator.ResetProbability = 0
+	setup(conf.Simulator)
+	client := New()
+	if err := client.Setup(conf); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)
+	client.Now = func() time.Time { return now }
+	return client, &now
+}
+
+func TestSimulatorDiurnalCurve(t *testing.T) {
+	client, now := newSimulator(t, func(conf *config.SimulatorConfiguration) {
+		conf.Noise = 0
+	})
+
+	*now = time.Date(2016, 2, 1, 9, 0, 0, 0, time.UTC)
+	offPeak, err := client.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	*now = time.Date(2016, 2, 1, 21, 0, 0, 0, time.UTC)
+	peak, err := client.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.6 * 100 Mbit/s
+	if peak.RateDown != 7500000 {
+		t.Fatalf("Invalid peak rate: %v", peak)
+	}
+	if offPeak.RateDown >= peak.RateDown || offPeak.RateDown <= 0 {
+		t.Fatalf("Invalid off-peak rate: %v / %v", offPeak, peak)
+	}
+	// Counters follow the rates
+	if peak.BytesDown != peak.RateDown*12*3600 || peak.Uptime != 12*3600 {
+		t.Fatalf("Invalid counters: %v", peak)
+	}
+}
+
+func TestSimulatorOutage(t *testing.T) {
+	client, now := newSimulator(t, func(conf *config.SimulatorConfiguration) {
+		conf.OutageProbability = 1
+		conf.OutageDuration = 60
+	})
+
+	resp, err := client.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.State != stateDown || resp.RateDown != 0 || resp.BandwidthDown != 0 {
+		t.Fatalf("Invalid outage statistics: %v", resp)
+	}
+	client.Config.OutageProbability = 0
+	*now = now.Add(30 * time.Second)
+	if resp, _ := client.Statistics(); resp.State != stateDown {
+		t.Fatalf("Outage too short: %v", resp)
+	}
+	*now = now.Add(31 * time.Second)
+	if resp, _ := client.Statistics(); resp.State != stateUp || resp.Uptime != 0 {
+		t.Fatalf("Outage too long: %v", resp)
+	}
+}
+
+func TestSimulatorCountersReset(t *testing.T) {
+	client, now := newSimulator(t, func(conf *config.SimulatorConfiguration) {})
+
+	client.Statistics()
+	*now = now.Add(time.Minute)
+	before, _ := client.Statistics()
+	if before.BytesDown == 0 {
+		t.Fatalf("Counters not incremented: %v", before)
+	}
+	client.Config.ResetProbability = 1
+	*now = now.Add(time.Minute)
+	after, _ := client.Statistics()
+	if after.Uptime != 0 || after.BytesDown > after.RateDown*60 {
+		t.Fatalf("Counters not reset: %v / %v", before, after)
+	}
+}
+
+func TestSimulatorInvalidConfiguration(t *testing.T) {
+	conf := config.New()
+	conf.Simulator.BandwidthDown = 0
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with an invalid bandwidth")
+	}
+}
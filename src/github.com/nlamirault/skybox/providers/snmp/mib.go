@@ -0,0 +1,199 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// IF-MIB objects
+	ifDescrOID       = ".1.3.6.1.2.1.2.2.1.2"
+	ifSpeedOID       = ".1.3.6.1.2.1.2.2.1.5"
+	ifOperStatusOID  = ".1.3.6.1.2.1.2.2.1.8"
+	ifInOctetsOID    = ".1.3.6.1.2.1.2.2.1.10"
+	ifOutOctetsOID   = ".1.3.6.1.2.1.2.2.1.16"
+	ifNameOID        = ".1.3.6.1.2.1.31.1.1.1.1"
+	ifHCInOctetsOID  = ".1.3.6.1.2.1.31.1.1.1.6"
+	ifHCOutOctetsOID = ".1.3.6.1.2.1.31.1.1.1.10"
+	ifHighSpeedOID   = ".1.3.6.1.2.1.31.1.1.1.15"
+
+	// ifOperStatus up(1)
+	ifOperStatusUp = 1
+
+	stateUp   = "up"
+	stateDown = "down"
+)
+
+// ifCounters represents the IF-MIB counters of an interface
+type ifCounters struct {
+	// ifHCInOctets, or ifInOctets with SNMPv1
+	InOctets int
+	// ifHCOutOctets, or ifOutOctets with SNMPv1
+	OutOctets int
+	// ifHighSpeed, or ifSpeed with SNMPv1, in bit/s
+	Speed int
+	// ifOperStatus
+	OperStatus int
+}
+
+// wrapCounter extends a 32 bits counter into a total, assuming it wraps
+// at most once between two polls
+type wrapCounter struct {
+	last  int
+	total int
+	seen  bool
+}
+
+// Update records the counter value, and returns the total
+func (w *wrapCounter) Update(value int) int {
+	switch {
+	case !w.seen:
+		w.total = value
+		w.seen = true
+	case value < w.last:
+		w.total += 1<<32 - w.last + value
+	default:
+		w.total += value - w.last
+	}
+	w.last = value
+	return w.total
+}
+
+// interfaceIndexes returns the ifIndex of the configured interfaces.
+// An interface could be an ifIndex, an ifName or an ifDescr.
+func (c *Client) interfaceIndexes() ([]int, error) {
+	var names []gosnmp.SnmpPDU
+	var indexes []int
+	walked := false
+	for _, iface := range c.Interfaces {
+		if index, err := strconv.Atoi(iface); err == nil {
+			indexes = append(indexes, index)
+			continue
+		}
+		if !walked {
+			ifNames, err := c.walk(ifNameOID)
+			if err != nil {
+				return nil, err
+			}
+			ifDescrs, err := c.walk(ifDescrOID)
+			if err != nil {
+				return nil, err
+			}
+			names = append(ifNames, ifDescrs...)
+			walked = true
+		}
+		index, err := findInterfaceIndex(names, iface)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	log.Printf("[DEBUG] SNMP interfaces %v: %v", c.Interfaces, indexes)
+	return indexes, nil
+}
+
+func findInterfaceIndex(pdus []gosnmp.SnmpPDU, name string) (int, error) {
+	for _, pdu := range pdus {
+		var value string
+		switch v := pdu.Value.(type) {
+		case []byte:
+			value = string(v)
+		case string:
+			value = v
+		}
+		if value != name {
+			continue
+		}
+		i := strings.LastIndex(pdu.Name, ".")
+		index, err := strconv.Atoi(pdu.Name[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("SNMP invalid interface OID: %s", pdu.Name)
+		}
+		return index, nil
+	}
+	return 0, fmt.Errorf("SNMP interface not found: %s", name)
+}
+
+func (c *Client) walk(oid string) ([]gosnmp.SnmpPDU, error) {
+	if c.SNMP.Version == gosnmp.Version1 {
+		return c.SNMP.WalkAll(oid)
+	}
+	return c.SNMP.BulkWalkAll(oid)
+}
+
+// counters retrieve the IF-MIB counters of an interface. SNMPv1 can't
+// carry the 64 bits counters of the ifXTable, so the 32 bits counters of
+// the ifTable are used, and extended across their wraps.
+func (c *Client) counters(index int) (*ifCounters, error) {
+	v1 := c.SNMP.Version == gosnmp.Version1
+	oids := []string{
+		fmt.Sprintf("%s.%d", ifHCInOctetsOID, index),
+		fmt.Sprintf("%s.%d", ifHCOutOctetsOID, index),
+		fmt.Sprintf("%s.%d", ifHighSpeedOID, index),
+		fmt.Sprintf("%s.%d", ifOperStatusOID, index),
+	}
+	if v1 {
+		oids[0] = fmt.Sprintf("%s.%d", ifInOctetsOID, index)
+		oids[1] = fmt.Sprintf("%s.%d", ifOutOctetsOID, index)
+		oids[2] = fmt.Sprintf("%s.%d", ifSpeedOID, index)
+	}
+	packet, err := c.SNMP.Get(oids)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("SNMP error for interface %d: %s", index, packet.Error)
+	}
+	values := map[string]int{}
+	for _, pdu := range packet.Variables {
+		switch pdu.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+			return nil, fmt.Errorf("SNMP object not available: %s", pdu.Name)
+		}
+		values[pdu.Name] = int(gosnmp.ToBigInt(pdu.Value).Int64())
+	}
+	counters := &ifCounters{
+		InOctets:   values[oids[0]],
+		OutOctets:  values[oids[1]],
+		Speed:      values[oids[2]] * 1000000,
+		OperStatus: values[oids[3]],
+	}
+	if v1 {
+		counters.InOctets = c.wrap(oids[0], counters.InOctets)
+		counters.OutOctets = c.wrap(oids[1], counters.OutOctets)
+		counters.Speed = values[oids[2]]
+	}
+	log.Printf("[DEBUG] SNMP interface %d counters: %v", index, counters)
+	return counters, nil
+}
+
+// wrap returns the total of a 32 bits counter
+func (c *Client) wrap(oid string, value int) int {
+	if c.wraps == nil {
+		c.wraps = map[string]*wrapCounter{}
+	}
+	counter, ok := c.wraps[oid]
+	if !ok {
+		counter = &wrapCounter{}
+		c.wraps[oid] = counter
+	}
+	return counter.Update(value)
+}
@@ -0,0 +1,244 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	defaultPort = "161"
+
+	sysUpTimeOID = ".1.3.6.1.2.1.1.3.0"
+)
+
+func init() {
+	providers.Add("snmp", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client is the SNMP client
+type Client struct {
+	SNMP *gosnmp.GoSNMP
+	// Interfaces are the monitored interfaces: ifIndex, ifName or ifDescr
+	Interfaces []string
+	// Indexes are the ifIndex of the monitored interfaces
+	Indexes []int
+	// Meter computes the rates from the octets counters
+	Meter providers.RateMeter
+
+	// wraps are the totals of the SNMPv1 32 bits counters, by OID
+	wraps map[string]*wrapCounter
+}
+
+// New returns a SNMP Client
+func New() *Client {
+	return &Client{
+		SNMP: &gosnmp.GoSNMP{
+			Port:      161,
+			Community: "public",
+			Version:   gosnmp.Version2c,
+			Timeout:   gosnmp.Default.Timeout,
+			Retries:   gosnmp.Default.Retries,
+			MaxOids:   gosnmp.MaxOids,
+		},
+	}
+}
+
+func (c *Client) Description() string {
+	return "snmp"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return &url.URL{
+		Scheme: "snmp",
+		Host:   net.JoinHostPort(c.SNMP.Target, strconv.Itoa(int(c.SNMP.Port))),
+	}
+}
+
+// GetHTTPClient returns nil, SNMP doesn't use HTTP
+func (c *Client) GetHTTPClient() *http.Client {
+	return nil
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.SNMP == nil {
+		return fmt.Errorf("SNMP configuration not found: %v", config)
+	}
+	address := config.SNMP.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("SNMP configuration invalid: %s", err.Error())
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("SNMP configuration invalid port: %s", err.Error())
+	}
+	if len(config.SNMP.Interfaces) == 0 {
+		return fmt.Errorf("SNMP configuration without interfaces")
+	}
+	c.SNMP.Target = host
+	c.SNMP.Port = uint16(p)
+	c.Interfaces = config.SNMP.Interfaces
+	if config.SNMP.Timeout > 0 {
+		c.SNMP.Timeout = time.Second * time.Duration(config.SNMP.Timeout)
+	}
+	switch config.SNMP.Version {
+	case "1":
+		c.SNMP.Version = gosnmp.Version1
+		c.SNMP.Community = config.SNMP.Community
+	case "", "2c":
+		c.SNMP.Version = gosnmp.Version2c
+		c.SNMP.Community = config.SNMP.Community
+	case "3":
+		return c.setupV3(config.SNMP)
+	default:
+		return fmt.Errorf("SNMP configuration invalid version: %s", config.SNMP.Version)
+	}
+	return nil
+}
+
+func (c *Client) setupV3(config *config.SNMPConfiguration) error {
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:                 config.Username,
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		AuthenticationPassphrase: config.AuthPassword,
+		PrivacyProtocol:          gosnmp.NoPriv,
+		PrivacyPassphrase:        config.PrivPassword,
+	}
+	switch strings.ToUpper(config.AuthProtocol) {
+	case "":
+	case "MD5":
+		params.AuthenticationProtocol = gosnmp.MD5
+	case "SHA":
+		params.AuthenticationProtocol = gosnmp.SHA
+	case "SHA256":
+		params.AuthenticationProtocol = gosnmp.SHA256
+	case "SHA512":
+		params.AuthenticationProtocol = gosnmp.SHA512
+	default:
+		return fmt.Errorf("SNMP configuration invalid auth protocol: %s", config.AuthProtocol)
+	}
+	switch strings.ToUpper(config.PrivProtocol) {
+	case "":
+	case "DES":
+		params.PrivacyProtocol = gosnmp.DES
+	case "AES":
+		params.PrivacyProtocol = gosnmp.AES
+	case "AES256":
+		params.PrivacyProtocol = gosnmp.AES256
+	default:
+		return fmt.Errorf("SNMP configuration invalid privacy protocol: %s", config.PrivProtocol)
+	}
+	flags := gosnmp.NoAuthNoPriv
+	if params.AuthenticationProtocol != gosnmp.NoAuth {
+		flags = gosnmp.AuthNoPriv
+		if params.PrivacyProtocol != gosnmp.NoPriv {
+			flags = gosnmp.AuthPriv
+		}
+	} else if params.PrivacyProtocol != gosnmp.NoPriv {
+		return fmt.Errorf("SNMP configuration with privacy requires authentication")
+	}
+	c.SNMP.Version = gosnmp.Version3
+	c.SNMP.SecurityModel = gosnmp.UserSecurityModel
+	c.SNMP.MsgFlags = flags
+	c.SNMP.SecurityParameters = params
+	return nil
+}
+
+// Ping connect to the SNMP agent, and retrieve its uptime
+func (c *Client) Ping() error {
+	if c.SNMP.Conn == nil {
+		if err := c.SNMP.Connect(); err != nil {
+			return err
+		}
+	}
+	packet, err := c.SNMP.Get([]string{sysUpTimeOID})
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] SNMP Ping received: %v", packet.Variables)
+	return nil
+}
+
+// Authenticate checks the credentials, and resolves the interfaces indexes
+func (c *Client) Authenticate() error {
+	if err := c.Ping(); err != nil {
+		return err
+	}
+	indexes, err := c.interfaceIndexes()
+	if err != nil {
+		return err
+	}
+	c.Indexes = indexes
+	return nil
+}
+
+// Close closes the connection to the SNMP agent
+func (c *Client) Close() error {
+	if c.SNMP.Conn == nil {
+		return nil
+	}
+	err := c.SNMP.Conn.Close()
+	c.SNMP.Conn = nil
+	return err
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] SNMP retrieve statistics\n")
+	if c.Indexes == nil {
+		if err := c.Authenticate(); err != nil {
+			return nil, err
+		}
+	}
+	stats := &providers.ProviderConnectionStatistics{
+		State: stateUp,
+	}
+	for _, index := range c.Indexes {
+		counters, err := c.counters(index)
+		if err != nil {
+			return nil, err
+		}
+		stats.BytesDown += counters.InOctets
+		stats.BytesUp += counters.OutOctets
+		stats.BandwidthDown += counters.Speed
+		stats.BandwidthUp += counters.Speed
+		if counters.OperStatus != ifOperStatusUp {
+			stats.State = stateDown
+		}
+	}
+	stats.RateUp, stats.RateDown = c.Meter.Update(stats.BytesUp, stats.BytesDown, time.Now())
+	log.Printf("[DEBUG] SNMP interfaces statistics received")
+	return stats, nil
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snmp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/nlamirault/skybox/config"
+)
+
+// newAgent starts a fake SNMP v2c agent replying to Get requests
+func newAgent(t *testing.T, objects map[string]gosnmp.SnmpPDU) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c, Logger: gosnmp.NewLogger(nil)}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request, err := decoder.SnmpDecodePacket(buf[:n])
+			if err != nil {
+				continue
+			}
+			response := &gosnmp.SnmpPacket{
+				Version:   request.Version,
+				Community: request.Community,
+				PDUType:   gosnmp.GetResponse,
+				RequestID: request.RequestID,
+			}
+			for _, variable := range request.Variables {
+				pdu, ok := objects[variable.Name]
+				if !ok {
+					pdu = gosnmp.SnmpPDU{Name: variable.Name, Type: gosnmp.NoSuchObject}
+				}
+				response.Variables = append(response.Variables, pdu)
+			}
+			data, err := response.MarshalMsg()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(data, addr)
+		}
+	}()
+	return conn
+}
+
+func TestFindInterfaceIndex(t *testing.T) {
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.1", Type: gosnmp.OctetString, Value: []byte("lo")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.12", Type: gosnmp.OctetString, Value: []byte("wan")},
+	}
+	index, err := findInterfaceIndex(pdus, "wan")
+	if err != nil {
+		t.Fatalf("Error finding interface: %v", err)
+	}
+	if index != 12 {
+		t.Fatalf("Invalid interface index: %d", index)
+	}
+	if _, err := findInterfaceIndex(pdus, "eth0"); err == nil {
+		t.Fatalf("Unknown interface found")
+	}
+}
+
+func TestSNMPStatistics(t *testing.T) {
+	agent := newAgent(t, map[string]gosnmp.SnmpPDU{
+		".1.3.6.1.2.1.1.3.0":          {Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.31.1.1.1.6.2":   {Name: ".1.3.6.1.2.1.31.1.1.1.6.2", Type: gosnmp.Counter64, Value: uint64(8000000000)},
+		".1.3.6.1.2.1.31.1.1.1.10.2":  {Name: ".1.3.6.1.2.1.31.1.1.1.10.2", Type: gosnmp.Counter64, Value: uint64(2000)},
+		".1.3.6.1.2.1.31.1.1.1.15.2":  {Name: ".1.3.6.1.2.1.31.1.1.1.15.2", Type: gosnmp.Gauge32, Value: uint(1000)},
+		".1.3.6.1.2.1.2.2.1.8.2":      {Name: ".1.3.6.1.2.1.2.2.1.8.2", Type: gosnmp.Integer, Value: 1},
+		".1.3.6.1.2.1.31.1.1.1.6.99":  {Name: ".1.3.6.1.2.1.31.1.1.1.6.99", Type: gosnmp.Counter64, Value: uint64(0)},
+		".1.3.6.1.2.1.31.1.1.1.10.99": {Name: ".1.3.6.1.2.1.31.1.1.1.10.99", Type: gosnmp.Counter64, Value: uint64(0)},
+	})
+	defer agent.Close()
+
+	conf := config.New()
+	conf.SNMP.Address = agent.LocalAddr().String()
+	conf.SNMP.Interfaces = []string{"2"}
+	client := New()
+	if err := client.Setup(conf); err != nil {
+		t.Fatalf("Error SNMP setup: %v", err)
+	}
+	resp, err := client.Statistics()
+	if err != nil {
+		t.Fatalf("Error SNMP statistics: %v", err)
+	}
+	if resp.BytesDown != 8000000000 || resp.BytesUp != 2000 ||
+		resp.BandwidthDown != 1000000000 || resp.State != stateUp {
+		t.Fatalf("SNMP statistics response: %v", resp)
+	}
+
+	client.Indexes = []int{99}
+	if _, err := client.Statistics(); err == nil {
+		t.Fatalf("No error with an unavailable object")
+	}
+}
+
+func TestSNMPv3Setup(t *testing.T) {
+	conf := config.New()
+	conf.SNMP.Address = "192.168.1.1"
+	conf.SNMP.Version = "3"
+	conf.SNMP.Interfaces = []string{"wan"}
+	conf.SNMP.Username = "skybox"
+	conf.SNMP.AuthProtocol = "sha"
+	conf.SNMP.AuthPassword = "authpassword"
+	conf.SNMP.PrivProtocol = "aes"
+	conf.SNMP.PrivPassword = "privpassword"
+	client := New()
+	if err := client.Setup(conf); err != nil {
+		t.Fatalf("Error SNMP setup: %v", err)
+	}
+	if client.SNMP.Port != 161 || client.SNMP.Version != gosnmp.Version3 ||
+		client.SNMP.MsgFlags != gosnmp.AuthPriv {
+		t.Fatalf("Invalid SNMP v3 setup: %v", client.SNMP)
+	}
+
+	conf.SNMP.AuthProtocol = ""
+	if err := New().Setup(conf); err == nil {
+		t.Fatalf("No error with privacy without authentication")
+	}
+}
+
+func TestSNMPv1Statistics(t *testing.T) {
+	objects := map[string]gosnmp.SnmpPDU{
+		".1.3.6.1.2.1.1.3.0":      {Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4200)},
+		".1.3.6.1.2.1.2.2.1.10.2": {Name: ".1.3.6.1.2.1.2.2.1.10.2", Type: gosnmp.Counter32, Value: uint(4294967000)},
+		".1.3.6.1.2.1.2.2.1.16.2": {Name: ".1.3.6.1.2.1.2.2.1.16.2", Type: gosnmp.Counter32, Value: uint(2000)},
+		".1.3.6.1.2.1.2.2.1.5.2":  {Name: ".1.3.6.1.2.1.2.2.1.5.2", Type: gosnmp.Gauge32, Value: uint(100000000)},
+		".1.3.6.1.2.1.2.2.1.8.2":  {Name: ".1.3.6.1.2.1.2.2.1.8.2", Type: gosnmp.Integer, Value: 1},
+	}
+	agent := newAgent(t, objects)
+	defer agent.Close()
+
+	conf := config.New()
+	conf.SNMP.Address = agent.LocalAddr().String()
+	conf.SNMP.Interfaces = []string{"2"}
+	conf.SNMP.Version = "1"
+	client := New()
+	if err := client.Setup(conf); err != nil {
+		t.Fatalf("Error SNMP setup: %v", err)
+	}
+	resp, err := client.Statistics()
+	if err != nil {
+		t.Fatalf("Error SNMP statistics: %v", err)
+	}
+	if resp.BytesDown != 4294967000 || resp.BytesUp != 2000 || resp.BandwidthDown != 100000000 {
+		t.Fatalf("SNMP statistics response: %v", resp)
+	}
+
+	// ifInOctets wraps
+	objects[".1.3.6.1.2.1.2.2.1.10.2"] = gosnmp.SnmpPDU{Name: ".1.3.6.1.2.1.2.2.1.10.2", Type: gosnmp.Counter32, Value: uint(704)}
+	resp, err = client.Statistics()
+	if err != nil {
+		t.Fatalf("Error SNMP statistics: %v", err)
+	}
+	if resp.BytesDown != 4294968000 || resp.BytesUp != 2000 {
+		t.Fatalf("SNMP statistics after wrap: %v", resp)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package providers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SOAPMediaType is the content type of SOAP requests
+	SOAPMediaType = `text/xml; charset="utf-8"`
+
+	soapEnvelopeStart = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
+	soapEnvelopeEnd = `</s:Body></s:Envelope>`
+)
+
+// SOAPError represents a UPnP error returned into a SOAP fault
+type SOAPError struct {
+	StatusCode  int    `xml:"-"`
+	Code        int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+	Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+}
+
+func (s *SOAPError) Error() string {
+	return fmt.Sprintf("%d / %d %s", s.StatusCode, s.Code, s.Description)
+}
+
+func createSOAPBody(serviceType, action string, args map[string]string) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(soapEnvelopeStart)
+	fmt.Fprintf(buf, `<u:%s xmlns:u="%s">`, action, serviceType)
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(buf, "<%s>", key)
+		if err := xml.EscapeText(buf, []byte(args[key])); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "</%s>", key)
+	}
+	fmt.Fprintf(buf, "</u:%s>", action)
+	buf.WriteString(soapEnvelopeEnd)
+	return buf, nil
+}
+
+// decodeSOAPResponse extract the output arguments of the action response
+func decodeSOAPResponse(r io.Reader, action string) (map[string]string, error) {
+	result := map[string]string{}
+	decoder := xml.NewDecoder(r)
+	inResponse := false
+	var key string
+	var value bytes.Buffer
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == action+"Response" {
+				inResponse = true
+			} else if inResponse {
+				key = t.Name.Local
+				value.Reset()
+			}
+		case xml.CharData:
+			if key != "" {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == action+"Response" {
+				return result, nil
+			}
+			if key != "" && t.Name.Local == key {
+				result[key] = strings.TrimSpace(value.String())
+				key = ""
+			}
+		}
+	}
+	if !inResponse {
+		return nil, fmt.Errorf("No %sResponse into SOAP envelope", action)
+	}
+	return result, nil
+}
+
+// DoSOAP perform a SOAP action using the provider HTTP client.
+// urlStr is the control URL of the service
+// args contains the input arguments of the action
+// It returns the output arguments of the action, or a SOAPError
+// if the box replies with a fault.
+func DoSOAP(provider Provider, urlStr, serviceType, action string, args map[string]string) (map[string]string, error) {
+	u, err := getURL(provider.EndPoint(), urlStr)
+	if err != nil {
+		return nil, err
+	}
+	body, err := createSOAPBody(serviceType, action, args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	provider.SetupHeaders(req)
+	req.Header.Set("Content-Type", SOAPMediaType)
+	req.Header.Set("SOAPAction", fmt.Sprintf("%s#%s", serviceType, action))
+	log.Printf("[DEBUG] SOAP Request : %s#%s %s", serviceType, action, u)
+	resp, err := provider.GetHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return decodeSOAPResponse(resp.Body, action)
+	}
+	soapError := SOAPError{StatusCode: resp.StatusCode}
+	content, err := getResponseBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read SOAP Error : %s", err.Error())
+	}
+	if err := xml.Unmarshal([]byte(content), &soapError); err != nil {
+		soapError.Description = content
+	}
+	log.Printf("[DEBUG] SOAP Error: %v\n", soapError)
+	return nil, &soapError
+}
+
+// SOAPInt returns the integer value of an output argument
+func SOAPInt(result map[string]string, key string) (int, error) {
+	value, ok := result[key]
+	if !ok {
+		return 0, fmt.Errorf("SOAP response without %s: %v", key, result)
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("SOAP response invalid %s: %s", key, err.Error())
+	}
+	return int(i), nil
+}
@@ -0,0 +1,304 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	ssdpAddress = "239.255.255.250:1900"
+
+	wanCommonService = "urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"
+
+	connectionConnected = "Connected"
+)
+
+// igdDevices are the versions of the Internet Gateway Device searched
+var igdDevices = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+}
+
+// wanConnectionServices are the services providing the WAN connection status
+var wanConnectionServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// discover sends a SSDP M-SEARCH request for each version of the Internet
+// Gateway Device, and returns the location of the first device which
+// replies
+func discover(timeout time.Duration) (string, error) {
+	log.Printf("[DEBUG] UPnP discover Internet Gateway Device\n")
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	for _, device := range igdDevices {
+		request := strings.Join([]string{
+			"M-SEARCH * HTTP/1.1",
+			"HOST: " + ssdpAddress,
+			`MAN: "ssdp:discover"`,
+			fmt.Sprintf("MX: %d", int(timeout.Seconds())),
+			"ST: " + device,
+			"", ""}, "\r\n")
+		if _, err := conn.WriteTo([]byte(request), addr); err != nil {
+			return "", err
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("No Internet Gateway Device found: %s", err.Error())
+		}
+		location, err := parseSSDPResponse(buf[:n])
+		if err != nil {
+			log.Printf("[DEBUG] UPnP invalid SSDP response: %s", err.Error())
+			continue
+		}
+		log.Printf("[DEBUG] UPnP Internet Gateway Device found: %s", location)
+		return location, nil
+	}
+}
+
+// parseSSDPResponse returns the location of the device description
+func parseSSDPResponse(data []byte) (string, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("SSDP response status: %s", resp.Status)
+	}
+	if st := resp.Header.Get("ST"); !isIGDDevice(st) {
+		return "", fmt.Errorf("SSDP response for another device: %s", st)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("SSDP response without location")
+	}
+	return location, nil
+}
+
+// isIGDDevice reports whether the device type is a searched version of the
+// Internet Gateway Device
+func isIGDDevice(deviceType string) bool {
+	for _, device := range igdDevices {
+		if deviceType == device {
+			return true
+		}
+	}
+	return false
+}
+
+type apiService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type apiDevice struct {
+	DeviceType   string       `xml:"deviceType"`
+	FriendlyName string       `xml:"friendlyName"`
+	ModelName    string       `xml:"modelName"`
+	Services     []apiService `xml:"serviceList>service"`
+	Devices      []apiDevice  `xml:"deviceList>device"`
+}
+
+// apiDescriptionResponse is returned by requesting the device description location
+type apiDescriptionResponse struct {
+	URLBase string    `xml:"URLBase"`
+	Device  apiDevice `xml:"device"`
+}
+
+// controlURL returns the control URL of the first service with this type
+func (d *apiDevice) controlURL(serviceType string) string {
+	for _, service := range d.Services {
+		if service.ServiceType == serviceType {
+			return service.ControlURL
+		}
+	}
+	for _, device := range d.Devices {
+		if control := device.controlURL(serviceType); control != "" {
+			return control
+		}
+	}
+	return ""
+}
+
+func (c *Client) description() (*apiDescriptionResponse, error) {
+	log.Printf("[DEBUG] UPnP device description: %s\n", c.Location)
+	resp, err := c.Client.Get(c.Location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP device description not available: %s", resp.Status)
+	}
+	var description apiDescriptionResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&description); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] UPnP device description response: %v", description)
+	return &description, nil
+}
+
+// describe retrieve the device description, and setup the services
+// control URLs
+func (c *Client) describe() error {
+	description, err := c.description()
+	if err != nil {
+		return err
+	}
+	base := description.URLBase
+	if base == "" {
+		base = c.Location
+	}
+	endpoint, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	c.WANCommonControl = description.Device.controlURL(wanCommonService)
+	if c.WANCommonControl == "" {
+		return fmt.Errorf("UPnP device without %s", wanCommonService)
+	}
+	for _, service := range wanConnectionServices {
+		if control := description.Device.controlURL(service); control != "" {
+			c.WANConnectionService = service
+			c.WANConnectionControl = control
+			break
+		}
+	}
+	if c.WANConnectionControl == "" {
+		return fmt.Errorf("UPnP device without WAN connection service")
+	}
+	c.Endpoint = endpoint
+	c.ModelName = description.Device.ModelName
+	return nil
+}
+
+// apiTotalBytesResponse is returned by the `GetTotalBytesSent`
+// and `GetTotalBytesReceived` actions
+type apiTotalBytesResponse struct {
+	BytesUp   int
+	BytesDown int
+}
+
+func (c *Client) totalBytes() (*apiTotalBytesResponse, error) {
+	log.Printf("[DEBUG] UPnP total bytes\n")
+	sent, err := providers.DoSOAP(c, c.WANCommonControl, wanCommonService, "GetTotalBytesSent", nil)
+	if err != nil {
+		return nil, err
+	}
+	received, err := providers.DoSOAP(c, c.WANCommonControl, wanCommonService, "GetTotalBytesReceived", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiTotalBytesResponse{}
+	if resp.BytesUp, err = providers.SOAPInt(sent, "NewTotalBytesSent"); err != nil {
+		return nil, err
+	}
+	if resp.BytesDown, err = providers.SOAPInt(received, "NewTotalBytesReceived"); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] UPnP total bytes response: %v", resp)
+	return resp, nil
+}
+
+// apiLinkPropertiesResponse is returned by the `GetCommonLinkProperties` action
+type apiLinkPropertiesResponse struct {
+	// available upload bandwidth in bit/s
+	BandwidthUp int
+	// available download bandwidth in bit/s
+	BandwidthDown int
+	// Up, Down, Initializing or Unavailable
+	LinkStatus string
+}
+
+func (c *Client) linkProperties() (*apiLinkPropertiesResponse, error) {
+	log.Printf("[DEBUG] UPnP link properties\n")
+	result, err := providers.DoSOAP(c, c.WANCommonControl, wanCommonService, "GetCommonLinkProperties", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiLinkPropertiesResponse{
+		LinkStatus: result["NewPhysicalLinkStatus"],
+	}
+	if resp.BandwidthUp, err = providers.SOAPInt(result, "NewLayer1UpstreamMaxBitRate"); err != nil {
+		return nil, err
+	}
+	if resp.BandwidthDown, err = providers.SOAPInt(result, "NewLayer1DownstreamMaxBitRate"); err != nil {
+		return nil, err
+	}
+	log.Printf("[DEBUG] UPnP link properties response: %v", resp)
+	return resp, nil
+}
+
+// apiStatusInfoResponse is returned by the `GetStatusInfo`
+// and `GetExternalIPAddress` actions
+type apiStatusInfoResponse struct {
+	// Connected, Connecting, Disconnected, ...
+	Status string
+	// connection uptime in seconds
+	Uptime int
+	// public IPv4 address
+	IPv4 string
+}
+
+func (c *Client) statusInfo() (*apiStatusInfoResponse, error) {
+	log.Printf("[DEBUG] UPnP status info\n")
+	status, err := providers.DoSOAP(c, c.WANConnectionControl, c.WANConnectionService, "GetStatusInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &apiStatusInfoResponse{
+		Status: status["NewConnectionStatus"],
+	}
+	if resp.Uptime, err = providers.SOAPInt(status, "NewUptime"); err != nil {
+		return nil, err
+	}
+	if resp.Status == connectionConnected {
+		address, err := providers.DoSOAP(c, c.WANConnectionControl, c.WANConnectionService, "GetExternalIPAddress", nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.IPv4 = address["NewExternalIPAddress"]
+	}
+	log.Printf("[DEBUG] UPnP status info response: %v", resp)
+	return resp, nil
+}
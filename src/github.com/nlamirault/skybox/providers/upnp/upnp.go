@@ -0,0 +1,160 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upnp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nlamirault/skybox/config"
+	"github.com/nlamirault/skybox/providers"
+)
+
+const (
+	defaultDiscoveryTimeout = 3
+)
+
+func init() {
+	providers.Add("upnp", func() providers.Provider {
+		return New()
+	})
+}
+
+// Client is the UPnP Internet Gateway Device client
+type Client struct {
+	// The client to use when sending requests.
+	Client *http.Client
+	// Endpoint is the base URL for control requests.
+	Endpoint *url.URL
+	// Location is the URL of the device description. Discovered using
+	// SSDP if empty.
+	Location string
+	// DiscoveryTimeout is the time to wait for SSDP responses
+	DiscoveryTimeout time.Duration
+
+	ModelName            string
+	WANCommonControl     string
+	WANConnectionService string
+	WANConnectionControl string
+
+	// Meter computes the rates from the total bytes counters
+	Meter providers.RateMeter
+}
+
+// New returns a UPnP Client
+func New() *Client {
+	return &Client{
+		Client:           &http.Client{},
+		Endpoint:         &url.URL{},
+		DiscoveryTimeout: time.Second * defaultDiscoveryTimeout,
+	}
+}
+
+func (c *Client) Description() string {
+	return "upnp"
+}
+
+func (c *Client) EndPoint() *url.URL {
+	return c.Endpoint
+}
+
+func (c *Client) GetHTTPClient() *http.Client {
+	return c.Client
+}
+
+func (c *Client) SetupHeaders(request *http.Request) {
+	request.Header.Add("User-Agent", providers.UserAgent)
+}
+
+func (c *Client) Setup(config *config.Configuration) error {
+	if config.UPnP == nil {
+		return fmt.Errorf("UPnP configuration not found: %v", config)
+	}
+	if config.UPnP.URL != "" {
+		if _, err := url.Parse(config.UPnP.URL); err != nil {
+			return fmt.Errorf("UPnP configuration invalid: %s", err.Error())
+		}
+	}
+	c.Location = config.UPnP.URL
+	if config.UPnP.DiscoveryTimeout > 0 {
+		c.DiscoveryTimeout = time.Second * time.Duration(config.UPnP.DiscoveryTimeout)
+	}
+	return nil
+}
+
+// Ping discover the Internet Gateway Device if its location isn't
+// configured, and retrieve its description
+func (c *Client) Ping() error {
+	if c.Location == "" {
+		location, err := discover(c.DiscoveryTimeout)
+		if err != nil {
+			return err
+		}
+		c.Location = location
+	}
+	if err := c.describe(); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] UPnP Ping received: %s", c.ModelName)
+	return nil
+}
+
+// Authenticate setup the device services. UPnP doesn't need any
+// authentication.
+func (c *Client) Authenticate() error {
+	if c.WANCommonControl != "" {
+		return nil
+	}
+	return c.Ping()
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) Statistics() (*providers.ProviderConnectionStatistics, error) {
+	log.Printf("[DEBUG] UPnP retrieve statistics\n")
+	if err := c.Authenticate(); err != nil {
+		return nil, err
+	}
+	bytes, err := c.totalBytes()
+	if err != nil {
+		return nil, err
+	}
+	link, err := c.linkProperties()
+	if err != nil {
+		return nil, err
+	}
+	status, err := c.statusInfo()
+	if err != nil {
+		return nil, err
+	}
+	rateUp, rateDown := c.Meter.Update(bytes.BytesUp, bytes.BytesDown, time.Now())
+	log.Printf("[DEBUG] UPnP connection status received")
+	return &providers.ProviderConnectionStatistics{
+		RateDown:      rateDown,
+		RateUp:        rateUp,
+		BytesDown:     bytes.BytesDown,
+		BytesUp:       bytes.BytesUp,
+		BandwidthDown: link.BandwidthDown,
+		BandwidthUp:   link.BandwidthUp,
+		State:         status.Status,
+		IPv4:          status.IPv4,
+		Uptime:        status.Uptime,
+	}, nil
+}
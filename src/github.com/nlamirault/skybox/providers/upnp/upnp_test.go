@@ -0,0 +1,187 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlamirault/skybox/providers"
+)
+
+const wanConnectionService = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+func newUPnP(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	igd := New()
+	igd.Location = server.URL + "/rootDesc.xml"
+	return igd, server
+}
+
+func soapResponse(w http.ResponseWriter, action string, service string, args string) {
+	w.Header().Set("Content-Type", providers.SOAPMediaType)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body>
+</s:Envelope>`, action, service, args, action)
+}
+
+var bytesSent = 1000
+
+func fakeIGD(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rootDesc.xml" {
+		fmt.Fprintln(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+  <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+  <modelName>MiniUPnPd</modelName>
+  <deviceList>
+    <device>
+      <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+      <serviceList>
+        <service>
+          <serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+          <controlURL>/ctl/CmnIfCfg</controlURL>
+        </service>
+      </serviceList>
+      <deviceList>
+        <device>
+          <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+          <serviceList>
+            <service>
+              <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+              <controlURL>/ctl/IPConn</controlURL>
+            </service>
+          </serviceList>
+        </device>
+      </deviceList>
+    </device>
+  </deviceList>
+</device>
+</root>`)
+		return
+	}
+	switch r.Header.Get("SOAPAction") {
+	case wanCommonService + "#GetTotalBytesSent":
+		soapResponse(w, "GetTotalBytesSent", wanCommonService,
+			fmt.Sprintf("<NewTotalBytesSent>%d</NewTotalBytesSent>", bytesSent))
+	case wanCommonService + "#GetTotalBytesReceived":
+		soapResponse(w, "GetTotalBytesReceived", wanCommonService,
+			"<NewTotalBytesReceived>5000</NewTotalBytesReceived>")
+	case wanCommonService + "#GetCommonLinkProperties":
+		soapResponse(w, "GetCommonLinkProperties", wanCommonService,
+			`<NewWANAccessType>Ethernet</NewWANAccessType>
+<NewLayer1UpstreamMaxBitRate>1000000</NewLayer1UpstreamMaxBitRate>
+<NewLayer1DownstreamMaxBitRate>10000000</NewLayer1DownstreamMaxBitRate>
+<NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>`)
+	case wanConnectionService + "#GetStatusInfo":
+		soapResponse(w, "GetStatusInfo", wanConnectionService,
+			`<NewConnectionStatus>Connected</NewConnectionStatus>
+<NewLastConnectionError>ERROR_NONE</NewLastConnectionError>
+<NewUptime>120</NewUptime>`)
+	case wanConnectionService + "#GetExternalIPAddress":
+		soapResponse(w, "GetExternalIPAddress", wanConnectionService,
+			"<NewExternalIPAddress>198.51.100.7</NewExternalIPAddress>")
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	location, err := parseSSDPResponse([]byte("HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=120\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"USN: uuid:fc4ec57e-b051-11db-88f8-0060085db3f6::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"EXT:\r\n" +
+		"SERVER: Linux UPnP/1.1 MiniUPnPd/2.0\r\n" +
+		"LOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n" +
+		"\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing SSDP response: %v", err)
+	}
+	if location != "http://192.168.1.1:5000/rootDesc.xml" {
+		t.Fatalf("Invalid SSDP location: %s", location)
+	}
+}
+
+func TestParseSSDPResponseIGDv2(t *testing.T) {
+	location, err := parseSSDPResponse([]byte("HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=1800\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:2\r\n" +
+		"USN: uuid:75802409-bccb-40e7-8e6c-fa095ecce13e::urn:schemas-upnp-org:device:InternetGatewayDevice:2\r\n" +
+		"EXT:\r\n" +
+		"LOCATION: http://192.168.1.1:49000/igd2desc.xml\r\n" +
+		"\r\n"))
+	if err != nil {
+		t.Fatalf("Error parsing SSDP response: %v", err)
+	}
+	if location != "http://192.168.1.1:49000/igd2desc.xml" {
+		t.Fatalf("Invalid SSDP location: %s", location)
+	}
+	_, err = parseSSDPResponse([]byte("HTTP/1.1 200 OK\r\n" +
+		"ST: urn:schemas-upnp-org:device:MediaServer:1\r\n" +
+		"LOCATION: http://192.168.1.2:8200/rootDesc.xml\r\n" +
+		"\r\n"))
+	if err == nil {
+		t.Fatalf("No error with another device")
+	}
+}
+
+func TestUPnPDescription(t *testing.T) {
+	igd, server := newUPnP(fakeIGD)
+	defer server.Close()
+
+	if err := igd.Ping(); err != nil {
+		t.Fatalf("Error UPnP ping: %v", err)
+	}
+	if igd.ModelName != "MiniUPnPd" ||
+		igd.WANCommonControl != "/ctl/CmnIfCfg" ||
+		igd.WANConnectionControl != "/ctl/IPConn" ||
+		igd.WANConnectionService != wanConnectionService {
+		t.Fatalf("Invalid UPnP description: %v", igd)
+	}
+}
+
+func TestUPnPStatistics(t *testing.T) {
+	igd, server := newUPnP(fakeIGD)
+	defer server.Close()
+
+	bytesSent = 1000
+	resp, err := igd.Statistics()
+	if err != nil {
+		t.Fatalf("Error UPnP statistics: %v", err)
+	}
+	if resp.BytesUp != 1000 || resp.BytesDown != 5000 ||
+		resp.BandwidthUp != 1000000 || resp.BandwidthDown != 10000000 ||
+		resp.RateUp != 0 {
+		t.Fatalf("UPnP statistics response: %v", resp)
+	}
+	if resp.State != "Connected" || resp.Uptime != 120 || resp.IPv4 != "198.51.100.7" {
+		t.Fatalf("UPnP status info response: %v", resp)
+	}
+
+	igd.Meter.Update(1000, 5000, time.Now().Add(-2*time.Second))
+	bytesSent = 3000
+	resp, err = igd.Statistics()
+	if err != nil {
+		t.Fatalf("Error UPnP statistics: %v", err)
+	}
+	if resp.RateUp < 900 || resp.RateUp > 1000 || resp.RateDown != 0 {
+		t.Fatalf("UPnP rates not derived from counters: %v", resp)
+	}
+}
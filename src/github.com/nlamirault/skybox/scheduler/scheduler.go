@@ -0,0 +1,116 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// job is a function run at each interval
+type job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(tick time.Time)
+
+	mutex   sync.Mutex
+	running bool
+}
+
+// start marks the job as running. It returns false if the previous run
+// isn't finished.
+func (j *job) start() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.running {
+		return false
+	}
+	j.running = true
+	return true
+}
+
+func (j *job) done() {
+	j.mutex.Lock()
+	j.running = false
+	j.mutex.Unlock()
+}
+
+// Scheduler runs jobs at their own interval. The ticks are aligned on the
+// wall clock: a job every minute runs at the start of each minute. A tick
+// is skipped if the previous run of the job isn't finished.
+type Scheduler struct {
+	jobs []*job
+	// Now returns the current time
+	Now func() time.Time
+}
+
+// New returns a Scheduler
+func New() *Scheduler {
+	return &Scheduler{
+		Now: time.Now,
+	}
+}
+
+// Add adds a job run every interval
+func (s *Scheduler) Add(name string, interval time.Duration, run func(tick time.Time)) {
+	s.jobs = append(s.jobs, &job{Name: name, Interval: interval, Run: run})
+}
+
+// Run runs the jobs until stop is closed, then waits for the running jobs
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		log.Printf("[DEBUG] Scheduler job %s every %s", j.Name, j.Interval)
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			s.loop(j, stop, &wg)
+		}(j)
+	}
+	<-stop
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(j *job, stop <-chan struct{}, wg *sync.WaitGroup) {
+	for {
+		now := s.Now()
+		tick := NextTick(now, j.Interval)
+		timer := time.NewTimer(tick.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.start() {
+			log.Printf("[WARN] Scheduler job %s still running, tick %s skipped",
+				j.Name, tick.Format(time.RFC3339))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer j.done()
+			j.Run(tick)
+		}()
+	}
+}
+
+// NextTick returns the first multiple of the interval after now, since
+// the zero time. Intervals dividing a day are aligned on midnight UTC.
+func NextTick(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
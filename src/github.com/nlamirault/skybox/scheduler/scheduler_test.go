@@ -0,0 +1,83 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextTick(t *testing.T) {
+	now := time.Date(2016, 2, 1, 10, 4, 31, 500, time.UTC)
+	for _, test := range []struct {
+		Interval time.Duration
+		Tick     time.Time
+	}{
+		{time.Second, time.Date(2016, 2, 1, 10, 4, 32, 0, time.UTC)},
+		{time.Minute, time.Date(2016, 2, 1, 10, 5, 0, 0, time.UTC)},
+		{5 * time.Minute, time.Date(2016, 2, 1, 10, 5, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2016, 2, 1, 11, 0, 0, 0, time.UTC)},
+	} {
+		if tick := NextTick(now, test.Interval); !tick.Equal(test.Tick) {
+			t.Fatalf("Invalid tick every %s: %s", test.Interval, tick)
+		}
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	var mutex sync.Mutex
+	var fast, slow []time.Time
+	running, overlaps := 0, 0
+	s := New()
+	s.Add("fast", 10*time.Millisecond, func(tick time.Time) {
+		mutex.Lock()
+		fast = append(fast, tick)
+		mutex.Unlock()
+	})
+	s.Add("slow", 10*time.Millisecond, func(tick time.Time) {
+		mutex.Lock()
+		running++
+		if running > 1 {
+			overlaps++
+		}
+		slow = append(slow, tick)
+		mutex.Unlock()
+		time.Sleep(35 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	})
+	stop := make(chan struct{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		close(stop)
+	}()
+	s.Run(stop)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if running != 0 {
+		t.Fatalf("Run returned before the end of the jobs")
+	}
+	if overlaps != 0 || len(slow) == 0 || len(slow) >= len(fast) {
+		t.Fatalf("Invalid runs: %d fast, %d slow, %d overlaps", len(fast), len(slow), overlaps)
+	}
+	for _, tick := range fast {
+		if !tick.Equal(tick.Truncate(10 * time.Millisecond)) {
+			t.Fatalf("Tick not aligned: %s", tick)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selfstat holds the internal metrics of skybox: collection
+// durations, box API errors, re-authentications and output writes. They
+// are written to the output as the box statistics.
+package selfstat
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// stat is the fields of a measurement with a set of tags
+type stat struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+}
+
+// Registry holds the internal metrics, by measurement and tags
+type Registry struct {
+	mutex sync.Mutex
+	stats map[string]*stat
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		stats: map[string]*stat{},
+	}
+}
+
+// Default is the registry of the agent
+var Default = NewRegistry()
+
+// Add adds delta to the counter field of the measurement with the tags
+func Add(measurement string, tags map[string]string, field string, delta int64) {
+	Default.Add(measurement, tags, field, delta)
+}
+
+// Set sets the gauge field of the measurement with the tags
+func Set(measurement string, tags map[string]string, field string, value interface{}) {
+	Default.Set(measurement, tags, field, value)
+}
+
+// Points returns the points of the default registry
+func Points(now time.Time) ([]*client.Point, error) {
+	return Default.Points(now)
+}
+
+// Add adds delta to the counter field of the measurement with the tags
+func (r *Registry) Add(measurement string, tags map[string]string, field string, delta int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.get(measurement, tags)
+	count, _ := s.Fields[field].(int64)
+	s.Fields[field] = count + delta
+}
+
+// Set sets the gauge field of the measurement with the tags
+func (r *Registry) Set(measurement string, tags map[string]string, field string, value interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.get(measurement, tags).Fields[field] = value
+}
+
+// Points returns a point by measurement and tags, sorted by key
+func (r *Registry) Points(now time.Time) ([]*client.Point, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	keys := make([]string, 0, len(r.stats))
+	for key := range r.stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var points []*client.Point
+	for _, key := range keys {
+		s := r.stats[key]
+		fields := make(map[string]interface{}, len(s.Fields))
+		for name, value := range s.Fields {
+			fields[name] = value
+		}
+		pt, err := client.NewPoint(s.Measurement, s.Tags, fields, now)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+	return points, nil
+}
+
+func (r *Registry) get(measurement string, tags map[string]string) *stat {
+	key := statKey(measurement, tags)
+	s, ok := r.stats[key]
+	if !ok {
+		s = &stat{
+			Measurement: measurement,
+			Tags:        map[string]string{},
+			Fields:      map[string]interface{}{},
+		}
+		for name, value := range tags {
+			s.Tags[name] = value
+		}
+		r.stats[key] = s
+	}
+	return s
+}
+
+// statKey returns the measurement and the sorted tags
+func statKey(measurement string, tags map[string]string) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := []string{measurement}
+	for _, name := range names {
+		key = append(key, name+"="+tags[name])
+	}
+	return strings.Join(key, ",")
+}
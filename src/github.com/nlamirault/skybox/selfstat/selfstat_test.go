@@ -0,0 +1,49 @@
+// Copyright (C) 2016 Nicolas Lamirault <nicolas.lamirault@gmail.com>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selfstat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistryPoints(t *testing.T) {
+	r := NewRegistry()
+	tags := map[string]string{"output": "influxdb"}
+	r.Add("skybox_write", tags, "failures", 1)
+	r.Add("skybox_write", map[string]string{"output": "influxdb"}, "failures", 2)
+	r.Set("skybox_write", tags, "duration", 12.5)
+	r.Add("skybox_authentications", map[string]string{"box": "freebox"}, "count", 1)
+	tags["output"] = "graphite"
+
+	now := time.Now()
+	points, err := r.Points(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Invalid points: %v", points)
+	}
+	if points[0].Name() != "skybox_authentications" || points[0].Fields()["count"] != int64(1) {
+		t.Fatalf("Invalid authentications point: %s", points[0])
+	}
+	write := points[1]
+	if write.Tags()["output"] != "influxdb" || !write.Time().Equal(now) {
+		t.Fatalf("Invalid write point: %s", write)
+	}
+	if write.Fields()["failures"] != int64(3) || write.Fields()["duration"] != 12.5 {
+		t.Fatalf("Invalid write fields: %v", write.Fields())
+	}
+}
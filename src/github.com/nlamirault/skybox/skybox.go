@@ -20,8 +20,25 @@ import (
 
 	"github.com/mitchellh/cli"
 
+	_ "github.com/nlamirault/skybox/outputs/elasticsearch"
+	_ "github.com/nlamirault/skybox/outputs/file"
+	_ "github.com/nlamirault/skybox/outputs/graphite"
 	_ "github.com/nlamirault/skybox/outputs/influxdb"
+	_ "github.com/nlamirault/skybox/outputs/kafka"
+	_ "github.com/nlamirault/skybox/outputs/mqtt"
+	_ "github.com/nlamirault/skybox/outputs/nats"
+	_ "github.com/nlamirault/skybox/outputs/otlp"
+	_ "github.com/nlamirault/skybox/outputs/postgresql"
+	_ "github.com/nlamirault/skybox/outputs/statsd"
+	_ "github.com/nlamirault/skybox/outputs/webhook"
 	_ "github.com/nlamirault/skybox/providers/freebox"
+	_ "github.com/nlamirault/skybox/providers/fritzbox"
+	_ "github.com/nlamirault/skybox/providers/local"
+	_ "github.com/nlamirault/skybox/providers/openwrt"
+	_ "github.com/nlamirault/skybox/providers/replay"
+	_ "github.com/nlamirault/skybox/providers/simulator"
+	_ "github.com/nlamirault/skybox/providers/snmp"
+	_ "github.com/nlamirault/skybox/providers/upnp"
 	"github.com/nlamirault/skybox/version"
 )
 